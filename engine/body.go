@@ -0,0 +1,88 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrBodyTooLarge is returned by RequestStartup for requests with bodies larger
+// than the maximum size configured for the context, and by Exec, Eval and other
+// methods running scripts, for scripts reading such bodies via 'php://input'.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// requestBody wraps the body of HTTP requests read by PHP, enforcing the maximum
+// body size configured for the context.
+type requestBody struct {
+	reader   io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+
+	// Set once the request has started, after which the body is read by the
+	// script, and the response is rejected if the body exceeds the limit.
+	reject bool
+}
+
+// prepareBody prepares the body of the context's HTTP request for reading by
+// PHP, and parses any multipart form data if configured to. This is done before
+// acquiring an engine thread, as uploads may take a while to receive.
+func prepareBody(ctx *Context) error {
+	if ctx.Request != nil && ctx.MaxBodyBytes > 0 && ctx.Request.ContentLength > ctx.MaxBodyBytes {
+		return ErrBodyTooLarge
+	}
+
+	ctx.body = newRequestBody(ctx)
+
+	var err error
+	ctx.form, err = parseForm(ctx)
+
+	return err
+}
+
+// newRequestBody returns the body for the context's HTTP request, or nil if the
+// context has no request body.
+func newRequestBody(ctx *Context) *requestBody {
+	if ctx.Request == nil || ctx.Request.Body == nil {
+		return nil
+	}
+
+	return &requestBody{reader: ctx.Request.Body, limit: ctx.MaxBodyBytes}
+}
+
+// discard drops the remainder of the body without reading it, as the body may
+// no longer be read once the HTTP request has been completed. Scripts reading
+// the body afterwards see it end early.
+func (b *requestBody) discard() {
+	b.reader = bytes.NewReader(nil)
+}
+
+// Read reads from the request body, filling the buffer passed unless the end of
+// the body is reached. PHP assumes the body has been read in its entirety once
+// a read returns less data than requested, so short reads from the underlying
+// connection, e.g. for chunked bodies, are not passed through.
+func (b *requestBody) Read(p []byte) (int, error) {
+	if b.limit > 0 && int64(len(p)) > b.limit-b.read {
+		// Read a single byte past the limit, which tells us whether the body
+		// is larger than allowed.
+		p = p[:b.limit-b.read+1]
+	}
+
+	n, err := io.ReadFull(b.reader, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	b.read += int64(n)
+	if b.limit > 0 && b.read > b.limit {
+		n -= int(b.read - b.limit)
+		b.read, b.exceeded = b.limit, true
+		return n, ErrBodyTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var requestBodyTests = []struct {
+	body     string
+	limit    int64
+	expected string
+	exceeded bool
+}{
+	{"hello world", 0, "hello world", false},
+	{"hello world", 11, "hello world", false},
+	{"hello world", 5, "hello", true},
+	{"", 5, "", false},
+}
+
+func TestRequestBody(t *testing.T) {
+	for _, tt := range requestBodyTests {
+		b := &requestBody{reader: iotest.OneByteReader(strings.NewReader(tt.body)), limit: tt.limit}
+
+		var read []byte
+		buf := make([]byte, 4)
+		for {
+			n, err := b.Read(buf)
+			read = append(read, buf[:n]...)
+
+			// Short reads are only allowed at the end of the body.
+			if err == nil && n < len(buf) {
+				t.Errorf("requestBody.Read('%s'): short read of %d bytes", tt.body, n)
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		if string(read) != tt.expected {
+			t.Errorf("requestBody.Read('%s'): expected '%s', actual '%s'", tt.body, tt.expected, read)
+		}
+
+		if b.exceeded != tt.exceeded {
+			t.Errorf("requestBody.Read('%s'): expected exceeded to be %v, actual %v", tt.body, tt.exceeded, b.exceeded)
+		}
+	}
+
+	b := &requestBody{reader: strings.NewReader("hello world"), limit: 5}
+	if _, err := ioutil.ReadAll(b); err != ErrBodyTooLarge {
+		t.Errorf("requestBody.Read(): expected error '%s', actual '%v'", ErrBodyTooLarge, err)
+	}
+}
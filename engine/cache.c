@@ -0,0 +1,124 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <stdbool.h>
+
+#include <main/php.h>
+
+#include "value.h"
+#include "cache.h"
+#include "_cgo_export.h"
+
+PHP_FUNCTION(go_cache_get) /* {{{ */
+{
+	char *key;
+	size_t key_len;
+	zval *def = NULL;
+	int found = 0;
+
+	if (zend_parse_parameters(ZEND_NUM_ARGS(), "s|z", &key, &key_len, &def) == FAILURE) {
+		return;
+	}
+
+	zval result = engineCacheGet(key, key_len, &found);
+	if (!found) {
+		_value_destroy(&result);
+
+		if (def) {
+			RETURN_ZVAL(def, 1, 0);
+		}
+
+		RETURN_NULL();
+	}
+
+	value_copy(return_value, &result);
+	_value_destroy(&result);
+}
+
+static void cache_store(INTERNAL_FUNCTION_PARAMETERS, int add) {
+	char *key;
+	size_t key_len;
+	zval *value;
+	zend_long ttl = 0;
+
+	if (zend_parse_parameters(ZEND_NUM_ARGS(), "sz|l", &key, &key_len, &value, &ttl) == FAILURE) {
+		return;
+	}
+
+	RETURN_BOOL(engineCacheSet(key, key_len, value, ttl, add));
+}
+
+PHP_FUNCTION(go_cache_set) /* {{{ */
+{
+	cache_store(INTERNAL_FUNCTION_PARAM_PASSTHRU, 0);
+}
+
+PHP_FUNCTION(go_cache_add) /* {{{ */
+{
+	cache_store(INTERNAL_FUNCTION_PARAM_PASSTHRU, 1);
+}
+
+PHP_FUNCTION(go_cache_exists) /* {{{ */
+{
+	char *key;
+	size_t key_len;
+
+	if (zend_parse_parameters(ZEND_NUM_ARGS(), "s", &key, &key_len) == FAILURE) {
+		return;
+	}
+
+	RETURN_BOOL(engineCacheExists(key, key_len));
+}
+
+PHP_FUNCTION(go_cache_delete) /* {{{ */
+{
+	char *key;
+	size_t key_len;
+
+	if (zend_parse_parameters(ZEND_NUM_ARGS(), "s", &key, &key_len) == FAILURE) {
+		return;
+	}
+
+	RETURN_BOOL(engineCacheDelete(key, key_len));
+}
+
+PHP_FUNCTION(go_cache_inc) /* {{{ */
+{
+	char *key;
+	size_t key_len;
+	zend_long step = 1, ttl = 0;
+	long result = 0;
+
+	if (zend_parse_parameters(ZEND_NUM_ARGS(), "s|ll", &key, &key_len, &step, &ttl) == FAILURE) {
+		return;
+	}
+
+	if (!engineCacheIncr(key, key_len, step, ttl, &result)) {
+		RETURN_FALSE;
+	}
+
+	RETURN_LONG(result);
+}
+
+PHP_FUNCTION(go_cache_cas) /* {{{ */
+{
+	char *key;
+	size_t key_len;
+	zval *old, *new;
+
+	if (zend_parse_parameters(ZEND_NUM_ARGS(), "szz", &key, &key_len, &old, &new) == FAILURE) {
+		return;
+	}
+
+	RETURN_BOOL(engineCacheCAS(key, key_len, old, new));
+}
+
+PHP_FUNCTION(go_cache_clear) /* {{{ */
+{
+	if (zend_parse_parameters_none() == FAILURE) {
+		return;
+	}
+
+	RETURN_BOOL(engineCacheClear());
+}
@@ -0,0 +1,436 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "value.h"
+import "C"
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// The approximate memory used by each cache entry, in addition to its key and
+// value.
+const cacheEntryOverhead = 64
+
+// Cache represents an in-process key-value store shared between requests, and
+// between PHP and Go, similar to PHP's APCu extension. The cache registered via
+// SetCache is available to scripts via the following functions:
+//
+//	go_cache_get(string $key, mixed $default = null): mixed
+//	go_cache_set(string $key, mixed $value, int $ttl = 0): bool
+//	go_cache_add(string $key, mixed $value, int $ttl = 0): bool
+//	go_cache_exists(string $key): bool
+//	go_cache_delete(string $key): bool
+//	go_cache_inc(string $key, int $step = 1, int $ttl = 0): int|false
+//	go_cache_cas(string $key, mixed $old, mixed $new): bool
+//	go_cache_clear(): bool
+//
+// Values are stored as Go values, as converted from PHP values via ToInterface,
+// and may be any value supported by NewValue. Values returned by Get are shared,
+// and must not be modified.
+//
+// The cache is safe for concurrent use, including by requests running on a
+// WorkerPool.
+type Cache struct {
+	maxBytes int64
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+// cacheEntry represents a single value stored in a Cache.
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	size    int64
+	expires time.Time
+}
+
+// NewCache returns an empty cache using at most maxBytes of memory, as estimated
+// from the size of keys and values stored. The least recently used entries are
+// evicted once the limit is reached. The cache size is unlimited if maxBytes is
+// zero.
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// SetCache registers the cache passed as the cache used by the go_cache_*
+// functions in PHP. Passing nil removes the cache, in which case the functions
+// fail.
+func SetCache(c *Cache) error {
+	if engine == nil {
+		return errors.New("Cannot set cache without an active engine")
+	}
+
+	engine.lock.Lock()
+	engine.cache = c
+	engine.lock.Unlock()
+
+	return nil
+}
+
+// Get returns the value stored for the key passed, and whether the value exists
+// and has not expired.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e := c.get(key)
+	if e == nil {
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+// Set stores the value passed for the key passed, expiring after the TTL passed,
+// or never if zero. Set returns false if the value could not be stored, e.g. if
+// the value is larger than the cache.
+func (c *Cache) Set(key string, value interface{}, ttl time.Duration) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.set(key, value, ttl)
+}
+
+// Add stores the value passed for the key passed, as with Set, unless a value
+// already exists for the key, in which case Add returns false.
+func (c *Cache) Add(key string, value interface{}, ttl time.Duration) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.get(key) != nil {
+		return false
+	}
+
+	return c.set(key, value, ttl)
+}
+
+// Delete removes the value stored for the key passed, and returns false if no
+// such value exists.
+func (c *Cache) Delete(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.get(key) == nil {
+		return false
+	}
+
+	c.remove(c.entries[key])
+	return true
+}
+
+// Incr atomically increments the integer value stored for the key passed by the
+// step passed, and returns the resulting value. Missing values are stored as the
+// step passed, expiring after the TTL passed. Incr returns false for values that
+// are not integers.
+func (c *Cache) Incr(key string, step int64, ttl time.Duration) (int64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e := c.get(key)
+	if e == nil {
+		return step, c.set(key, step, ttl)
+	}
+
+	current, ok := cacheInt(e.value)
+	if !ok {
+		return 0, false
+	}
+
+	e.value = current + step
+	return current + step, true
+}
+
+// CAS atomically replaces the value stored for the key passed with the new value
+// passed, if the current value is equal to the old value passed. CAS returns
+// false if the value has not been replaced. The expiry time for the value is
+// kept as-is.
+func (c *Cache) CAS(key string, old, new interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e := c.get(key)
+	if e == nil || !cacheEqual(e.value, old) {
+		return false
+	}
+
+	ttl := time.Duration(0)
+	if !e.expires.IsZero() {
+		ttl = e.expires.Sub(time.Now())
+	}
+
+	return c.set(key, new, ttl)
+}
+
+// Clear removes all values stored in the cache.
+func (c *Cache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+// get returns the entry for the key passed, or nil if no entry exists or if the
+// entry has expired, and marks the entry as recently used.
+func (c *Cache) get(key string) *cacheEntry {
+	elem := c.entries[key]
+	if elem == nil {
+		return nil
+	}
+
+	e := elem.Value.(*cacheEntry)
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		c.remove(elem)
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+	return e
+}
+
+// set stores the value passed, evicting entries as needed for the value to fit.
+func (c *Cache) set(key string, value interface{}, ttl time.Duration) bool {
+	e := &cacheEntry{
+		key:   key,
+		value: value,
+		size:  int64(len(key)) + cacheSize(reflect.ValueOf(value)) + cacheEntryOverhead,
+	}
+
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	} else if ttl < 0 {
+		return false
+	}
+
+	if c.maxBytes > 0 && e.size > c.maxBytes {
+		return false
+	}
+
+	if elem := c.entries[key]; elem != nil {
+		c.remove(elem)
+	}
+
+	if c.maxBytes > 0 && c.size+e.size > c.maxBytes {
+		c.evict(c.size + e.size - c.maxBytes)
+	}
+
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += e.size
+
+	return true
+}
+
+// evict removes expired entries, followed by the least recently used entries,
+// until at least the number of bytes passed have been freed.
+func (c *Cache) evict(bytes int64) {
+	var freed int64
+	now := time.Now()
+
+	for elem := c.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if e := elem.Value.(*cacheEntry); !e.expires.IsZero() && !now.Before(e.expires) {
+			freed += e.size
+			c.remove(elem)
+		}
+
+		elem = prev
+	}
+
+	for freed < bytes && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		freed += elem.Value.(*cacheEntry).size
+		c.remove(elem)
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// cacheSize returns the approximate memory used by the value passed.
+func cacheSize(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len()) + 16
+	case reflect.Slice, reflect.Array:
+		size := int64(24)
+		for i := 0; i < v.Len(); i++ {
+			size += cacheSize(v.Index(i))
+		}
+
+		return size
+	case reflect.Map:
+		size := int64(48)
+		for _, key := range v.MapKeys() {
+			size += cacheSize(key) + cacheSize(v.MapIndex(key))
+		}
+
+		return size
+	case reflect.Struct:
+		size := int64(0)
+		for i := 0; i < v.NumField(); i++ {
+			size += cacheSize(v.Field(i))
+		}
+
+		return size
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return 8
+		}
+
+		return 8 + cacheSize(v.Elem())
+	}
+
+	return 8
+}
+
+// cacheInt returns the value passed as an integer, if it is one.
+func cacheInt(value interface{}) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	}
+
+	return 0, false
+}
+
+// cacheEqual returns true if the values passed are equal, treating integers and
+// floating point numbers of different sizes as equal, as values stored from PHP
+// are always 64 bits in size.
+func cacheEqual(a, b interface{}) bool {
+	if x, ok := cacheInt(a); ok {
+		y, ok := cacheInt(b)
+		return ok && x == y
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if (va.Kind() == reflect.Float32 || va.Kind() == reflect.Float64) && (vb.Kind() == reflect.Float32 || vb.Kind() == reflect.Float64) {
+		return va.Float() == vb.Float()
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// cacheStore returns the active cache, if any.
+func (e *Engine) cacheStore() *Cache {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.cache
+}
+
+//export engineCacheGet
+func engineCacheGet(key *C.char, length C.size_t, found *C.int) C.struct__zval_struct {
+	*found = 0
+
+	if c := engine.cacheStore(); c != nil {
+		if value, ok := c.Get(C.GoStringN(key, C.int(length))); ok {
+			if val, err := newValue(value); err == nil {
+				*found = 1
+				return *val
+			}
+		}
+	}
+
+	val, _ := newValue(nil)
+	return *val
+}
+
+//export engineCacheSet
+func engineCacheSet(key *C.char, length C.size_t, value *C.struct__zval_struct, ttl C.long, add C.int) C.int {
+	c := engine.cacheStore()
+	if c == nil {
+		return 0
+	}
+
+	k, v, t := C.GoStringN(key, C.int(length)), toInterface(value), time.Duration(ttl)*time.Second
+	if add == 1 {
+		return cacheResult(c.Add(k, v, t))
+	}
+
+	return cacheResult(c.Set(k, v, t))
+}
+
+//export engineCacheExists
+func engineCacheExists(key *C.char, length C.size_t) C.int {
+	c := engine.cacheStore()
+	if c == nil {
+		return 0
+	}
+
+	_, ok := c.Get(C.GoStringN(key, C.int(length)))
+	return cacheResult(ok)
+}
+
+//export engineCacheDelete
+func engineCacheDelete(key *C.char, length C.size_t) C.int {
+	c := engine.cacheStore()
+	if c == nil {
+		return 0
+	}
+
+	return cacheResult(c.Delete(C.GoStringN(key, C.int(length))))
+}
+
+//export engineCacheIncr
+func engineCacheIncr(key *C.char, length C.size_t, step C.long, ttl C.long, result *C.long) C.int {
+	c := engine.cacheStore()
+	if c == nil {
+		return 0
+	}
+
+	value, ok := c.Incr(C.GoStringN(key, C.int(length)), int64(step), time.Duration(ttl)*time.Second)
+	*result = C.long(value)
+
+	return cacheResult(ok)
+}
+
+//export engineCacheCAS
+func engineCacheCAS(key *C.char, length C.size_t, old *C.struct__zval_struct, new *C.struct__zval_struct) C.int {
+	c := engine.cacheStore()
+	if c == nil {
+		return 0
+	}
+
+	return cacheResult(c.CAS(C.GoStringN(key, C.int(length)), toInterface(old), toInterface(new)))
+}
+
+//export engineCacheClear
+func engineCacheClear() C.int {
+	c := engine.cacheStore()
+	if c == nil {
+		return 0
+	}
+
+	c.Clear()
+	return 1
+}
+
+func cacheResult(ok bool) C.int {
+	if ok {
+		return 1
+	}
+
+	return 0
+}
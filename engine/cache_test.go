@@ -0,0 +1,109 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(0)
+
+	if _, ok := c.Get("missing"); ok {
+		t.Errorf("Cache.Get(): expected missing value")
+	}
+
+	c.Set("a", "value", 0)
+	if v, ok := c.Get("a"); !ok || v != "value" {
+		t.Errorf("Cache.Get(): expected 'value', actual '%v'", v)
+	}
+
+	if c.Add("a", "other", 0) {
+		t.Errorf("Cache.Add(): overwrote existing value")
+	}
+
+	if n, ok := c.Incr("n", 2, 0); !ok || n != 2 {
+		t.Errorf("Cache.Incr(): expected 2, actual %d", n)
+	}
+
+	if n, ok := c.Incr("n", 3, 0); !ok || n != 5 {
+		t.Errorf("Cache.Incr(): expected 5, actual %d", n)
+	}
+
+	if _, ok := c.Incr("a", 1, 0); ok {
+		t.Errorf("Cache.Incr(): incremented string value")
+	}
+
+	if c.CAS("n", 4, 10) || !c.CAS("n", 5, 10) {
+		t.Errorf("Cache.CAS(): unexpected result for compare-and-swap")
+	}
+
+	if !c.Delete("a") || c.Delete("a") {
+		t.Errorf("Cache.Delete(): unexpected result for deleting value")
+	}
+
+	c.Set("ttl", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("ttl"); ok {
+		t.Errorf("Cache.Get(): expected expired value to be missing")
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	c := NewCache(200)
+
+	c.Set("a", "first", 0)
+	c.Set("b", "second", 0)
+	c.Get("a")
+	c.Set("c", "third", 0)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Cache.Set(): least recently used value not evicted")
+	}
+
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("Cache.Set(): recently used value evicted")
+	}
+
+	if c.Set("large", make([]byte, 1000), 0) {
+		t.Errorf("Cache.Set(): stored value larger than cache")
+	}
+}
+
+func Test_go_cache(t *testing.T) {
+	Initialize()
+	c := NewCache(0)
+	SetCache(c)
+	defer SetCache(nil)
+
+	c.Set("config", map[string]interface{}{"name": "go-php"}, 0)
+
+	evalAssert(&Context{}, `
+		go_cache_set('list', [1, 2, 3]);
+		go_cache_inc('counter');
+		go_cache_inc('counter', 2);
+		return [
+			go_cache_get('config')['name'],
+			go_cache_get('missing', 'default'),
+			go_cache_add('list', []),
+			go_cache_cas('counter', 3, 4),
+			go_cache_exists('list'),
+		];`, func(val evalAssertionArg) {
+		expected := []interface{}{"go-php", "default", false, true, true}
+		if actual := ToSlice(val.val); !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected %v, actual %v", expected, actual)
+		}
+	})
+
+	if v, _ := c.Get("counter"); v != int64(4) {
+		t.Errorf("go_cache_cas(): expected 4, actual %v", v)
+	}
+
+	if v, _ := c.Get("list"); !reflect.DeepEqual(v, []interface{}{int64(1), int64(2), int64(3)}) {
+		t.Errorf("go_cache_set(): expected [1 2 3], actual %v", v)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <errno.h>
+#include <string.h>
+
+#include <main/php.h>
+
+#include "constant.h"
+
+// Defines a case-sensitive constant for the current request. Constants may not
+// be redefined, and are removed on request shutdown.
+void constant_define(char *name, zval *value) {
+	if (!_constant_valid(value) || !_constant_register(name, value)) {
+		errno = 1;
+		return;
+	}
+
+	errno = 0;
+}
+
+// Returns 1 if a constant is defined for the name passed, either by PHP and its
+// extensions, or by the request running on the current thread, if any.
+int constant_defined(char *name) {
+	return zend_get_constant_str(name, strlen(name)) != NULL;
+}
+
+#include "_constant.c"
@@ -0,0 +1,163 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "constant.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// DefineConstant defines a case-sensitive PHP constant for the name and value
+// passed, available to all requests started after the call, including those
+// handled by worker pools. Values may be scalars, or slices and maps containing
+// scalars, as accepted by NewValue; objects cannot be used as constants.
+//
+// Unlike variables passed via Bind, constants cannot be modified by scripts, and
+// may be used in `const` expressions, e.g. class constants and default values.
+// Constants already defined by PHP or its extensions cannot be redefined.
+//
+// As PHP removes user-defined constants on request shutdown, constants are
+// defined anew at the start of each request, from a copy of the value passed.
+func DefineConstant(name string, value interface{}) error {
+	if engine == nil {
+		return errors.New("Cannot define constant without an active engine")
+	}
+
+	if err := checkConstant(name, value); err != nil {
+		return err
+	}
+
+	n := C.CString(name)
+	defer C.free(unsafe.Pointer(n))
+
+	var defined bool
+	engine.call(func() {
+		defined = C.constant_defined(n) == 1
+	})
+
+	if defined {
+		return fmt.Errorf("Failed to define duplicate constant '%s'", name)
+	}
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	if _, exists := engine.constants[name]; exists {
+		return fmt.Errorf("Failed to define duplicate constant '%s'", name)
+	}
+
+	engine.constants[name] = value
+	return nil
+}
+
+// DefineConstant defines a case-sensitive PHP constant for the name and value
+// passed, for the remainder of the request. Constants already defined, either
+// via the engine-wide DefineConstant or by the running script, cannot be
+// redefined.
+func (c *Context) DefineConstant(name string, value interface{}) error {
+	if err := checkConstant(name, value); err != nil {
+		return err
+	}
+
+	n := C.CString(name)
+	defer C.free(unsafe.Pointer(n))
+
+	var err error
+	c.thread.call(func() {
+		err = defineConstant(n, value)
+	})
+
+	if err != nil {
+		return fmt.Errorf("Failed to define constant '%s'", name)
+	}
+
+	return nil
+}
+
+// Defines all engine-wide constants for the request being started on the
+// current thread.
+func defineConstants() error {
+	engine.lock.RLock()
+	defer engine.lock.RUnlock()
+
+	for name, value := range engine.constants {
+		n := C.CString(name)
+		err := defineConstant(n, value)
+		C.free(unsafe.Pointer(n))
+
+		if err != nil {
+			return fmt.Errorf("Failed to define constant '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+func defineConstant(name *C.char, value interface{}) error {
+	v, err := newValue(value)
+	if err != nil {
+		return err
+	}
+
+	defer destroyValue(v)
+
+	_, err = C.constant_define(name, v)
+	return err
+}
+
+// Checks that the name and value passed are valid for a constant, so that
+// errors are reported on definition rather than on request startup.
+func checkConstant(name string, value interface{}) error {
+	if name == "" {
+		return errors.New("Cannot define constant with an empty name")
+	}
+
+	if !validConstant(reflect.ValueOf(value)) {
+		return fmt.Errorf("Invalid value of type '%T' for constant '%s'", value, name)
+	}
+
+	return nil
+}
+
+// Returns whether the value passed converts to a PHP value usable as a constant,
+// i.e. a scalar, or an array containing only scalars and arrays.
+func validConstant(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Bool, reflect.String:
+		return true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	case reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Interface:
+		return validConstant(v.Elem())
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if !validConstant(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if kt := v.Type().Key().Kind(); kt != reflect.Int && kt != reflect.String {
+			return false
+		}
+		for _, key := range v.MapKeys() {
+			if !validConstant(v.MapIndex(key)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var constantTests = []struct {
+	script string
+	value  interface{}
+}{
+	{"return GOPHP_APP_ENV;", "testing"},
+	{"return GOPHP_FEATURES['beta'];", true},
+	{"class GoPHPConfig { const VERSION = GOPHP_VERSIONS[1]; } return GoPHPConfig::VERSION;", "1.1"},
+	{"function gophp_env($env = GOPHP_APP_ENV) { return $env; } return gophp_env();", "testing"},
+	{"return GOPHP_REQUEST_ID;", int64(42)},
+	{"return defined('gophp_app_env');", false},
+}
+
+func TestDefineConstant(t *testing.T) {
+	Initialize()
+
+	if err := DefineConstant("GOPHP_APP_ENV", "testing"); err != nil {
+		t.Fatalf("DefineConstant(): %s", err)
+	}
+
+	DefineConstant("GOPHP_VERSIONS", []string{"1.0", "1.1"})
+	DefineConstant("GOPHP_FEATURES", map[string]bool{"beta": true})
+
+	if err := DefineConstant("GOPHP_APP_ENV", "production"); err == nil {
+		t.Errorf("DefineConstant(): expected error for duplicate constant")
+	}
+
+	if err := DefineConstant("PHP_VERSION", "0.0.0"); err == nil {
+		t.Errorf("DefineConstant(): expected error for constant defined by PHP")
+	}
+
+	if err := DefineConstant("GOPHP_OBJECT", struct{ Name string }{"test"}); err == nil {
+		t.Errorf("DefineConstant(): expected error for object value")
+	}
+
+	c := &Context{Output: &bytes.Buffer{}}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	if err := c.DefineConstant("GOPHP_REQUEST_ID", 42); err != nil {
+		t.Fatalf("Context.DefineConstant(): %s", err)
+	}
+
+	if err := c.DefineConstant("GOPHP_APP_ENV", "production"); err == nil {
+		t.Errorf("Context.DefineConstant(): expected error for redefined constant")
+	}
+
+	for _, tt := range constantTests {
+		val, err := c.Eval(tt.script)
+		if err != nil {
+			t.Errorf("Context.Eval('%s'): %s", tt.script, err)
+			continue
+		}
+
+		if result := ToInterface(val); !reflect.DeepEqual(result, tt.value) {
+			t.Errorf("Context.Eval('%s'): Expected value '%#v', actual '%#v'", tt.script, tt.value, result)
+		}
+
+		DestroyValue(val)
+	}
+}
+
+func TestContextDefineConstantRequestScope(t *testing.T) {
+	Initialize()
+
+	c := &Context{Output: &bytes.Buffer{}}
+	RequestStartup(c)
+	c.DefineConstant("GOPHP_SCOPED", "value")
+	RequestShutdown(c)
+
+	c = &Context{Output: &bytes.Buffer{}}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	val, _ := c.Eval("return defined('GOPHP_SCOPED');")
+	defer DestroyValue(val)
+
+	if result := ToInterface(val); result != false {
+		t.Errorf("Context.DefineConstant(): Expected constant to be removed after request, actual '%v'", result)
+	}
+}
@@ -7,10 +7,17 @@
 
 #include <main/php.h>
 #include <main/php_main.h>
+#include <main/php_variables.h>
 
 #include "value.h"
 #include "context.h"
 
+// Returns the string value for a server variable, or NULL if the variable is
+// not set.
+static char *context_string(zval *val) {
+	return (Z_TYPE_P(val) == IS_STRING) ? Z_STRVAL_P(val) : NULL;
+}
+
 engine_context *context_new(zval *server_values) {
 	engine_context *context;
 
@@ -21,19 +28,22 @@ engine_context *context_new(zval *server_values) {
 		return NULL;
 	}
 	context->is_finished = 0;
+	context->is_worker_started = 0;
+	context->uses_fs = 0;
+	_context_interrupt_init(context);
 
 	if (server_values) {
 		zval query_string = value_array_key_get(server_values, "QUERY_STRING");
-		SG(request_info).query_string = Z_STRVAL(query_string);
+		SG(request_info).query_string = context_string(&query_string);
 		context->query_string = query_string;
 		zval request_method = value_array_key_get(server_values, "REQUEST_METHOD");
-		SG(request_info).request_method = Z_STRVAL(request_method);
+		SG(request_info).request_method = context_string(&request_method);
 		context->request_method = request_method;
-		zval content_type = value_array_key_get(server_values, "HTTP_CONTENT_TYPE");
-		SG(request_info).content_type = Z_STRVAL(content_type);
+		zval content_type = value_array_key_get(server_values, "CONTENT_TYPE");
+		SG(request_info).content_type = context_string(&content_type);
 		context->content_type = content_type;
-		zval content_length = value_array_key_get(server_values, "HTTP_CONTENT_LENGTH");
-		SG(request_info).content_length = Z_LVAL(content_length);
+		zval content_length = value_array_key_get(server_values, "CONTENT_LENGTH");
+		SG(request_info).content_length = (Z_TYPE(content_length) == IS_LONG) ? Z_LVAL(content_length) : 0;
 		context->server_values = *server_values;
 		context->http_cookie = value_array_key_get(server_values, "HTTP_COOKIE");
 	} else {
@@ -63,6 +73,7 @@ void context_startup(engine_context *context) {
 		SG(server_context) = NULL;
 		free(context);
 		errno = 1;
+		return;
 	}
 	errno = 0;
 }
@@ -94,7 +105,38 @@ void context_exec(engine_context *context, char *filename) {
 	return;
 }
 
-zval context_eval(engine_context *context, char *script) {
+zval context_exec_value(engine_context *context, char *filename) {
+	int ret = FAILURE;
+	zval result;
+
+	ZVAL_NULL(&result);
+
+	// Attempt to execute script file, as is done for 'require'.
+	zend_first_try {
+		zend_file_handle script;
+
+		script.type = ZEND_HANDLE_FILENAME;
+		script.filename = filename;
+		script.opened_path = NULL;
+		script.free_filename = 0;
+
+		ret = zend_execute_scripts(ZEND_REQUIRE, &result, 1, &script);
+	} zend_catch {
+		ZVAL_NULL(&result);
+		errno = 1;
+		return result;
+	} zend_end_try();
+
+	if (ret == FAILURE) {
+		errno = 1;
+		return result;
+	}
+
+	errno = 0;
+	return result;
+}
+
+zval context_eval(engine_context *context, char *script, char *filename) {
 	zval str = _value_init();
 	ZVAL_STRING(&str, script);
 
@@ -102,7 +144,7 @@ zval context_eval(engine_context *context, char *script) {
 	uint32_t compiler_options = CG(compiler_options);
 
 	CG(compiler_options) = ZEND_COMPILE_DEFAULT_FOR_EVAL;
-	zend_op_array *op = zend_compile_string(&str, "gophp-engine");
+	zend_op_array *op = zend_compile_string(&str, filename);
 	CG(compiler_options) = compiler_options;
 
 	zval_dtor(&str);
@@ -126,6 +168,91 @@ void context_bind(engine_context *context, char *name, zval *value) {
 	_context_bind(name, value);
 }
 
+zval context_global(engine_context *context, char *name) {
+	zval result;
+	ZVAL_NULL(&result);
+
+	zval *val = _context_global_find(name);
+	if (val == NULL) {
+		errno = 1;
+		return result;
+	}
+
+	value_copy(&result, val);
+
+	errno = 0;
+	return result;
+}
+
+void context_unset(engine_context *context, char *name) {
+	_context_global_unset(name);
+}
+
+zval context_globals(engine_context *context) {
+	zval names;
+	_context_global_names(&names);
+
+	return names;
+}
+
+void context_skip_post_data(engine_context *context) {
+	// Request bodies are only parsed by PHP for requests with a content type.
+	SG(request_info).content_type = NULL;
+}
+
+void context_register_post(engine_context *context, char *name, char *value, size_t len) {
+	php_register_variable_safe(name, value, len, &PG(http_globals)[TRACK_VARS_POST]);
+}
+
+// Registers a single entry, e.g. 'tmp_name', for a file in $_FILES. Entries are
+// nested below the top-level field name, so that a file uploaded as 'file[a]'
+// has its name registered as 'file[name][a]', as is done by PHP.
+static void context_register_file_entry(char *name, char *entry, zval *value) {
+	char *index = strchr(name, '[');
+	int len = index ? index - name : strlen(name);
+
+	char *var = emalloc(strlen(name) + strlen(entry) + 3);
+	sprintf(var, "%.*s[%s]%s", len, name, entry, index ? index : "");
+
+	php_register_variable_ex(var, value, &PG(http_globals)[TRACK_VARS_FILES]);
+	efree(var);
+}
+
+void context_register_file(engine_context *context, char *name, char *filename, char *type, char *tmp_name, int error, long size, int uploaded) {
+	zval value;
+
+	ZVAL_STRING(&value, filename);
+	context_register_file_entry(name, "name", &value);
+
+	ZVAL_STRING(&value, type);
+	context_register_file_entry(name, "type", &value);
+
+	ZVAL_STRING(&value, tmp_name);
+	context_register_file_entry(name, "tmp_name", &value);
+
+	ZVAL_LONG(&value, error);
+	context_register_file_entry(name, "error", &value);
+
+	ZVAL_LONG(&value, size);
+	context_register_file_entry(name, "size", &value);
+
+	// Files uploaded to disk are tracked for is_uploaded_file() and
+	// move_uploaded_file(), and removed on request shutdown unless moved.
+	if (uploaded && error == 0 && *tmp_name != '\0') {
+		_context_register_uploaded_file(tmp_name);
+	}
+}
+
+// Terminates the script running in the context as if it had exceeded its maximum
+// execution time, as is done by PHP's own timeout handler. This may be called
+// from any thread.
+void context_interrupt(engine_context *context) {
+#if CONTEXT_INTERRUPT
+	*context->timed_out = 1;
+	*context->vm_interrupt = 1;
+#endif
+}
+
 void context_destroy(engine_context *context) {
 	context_dtor(context);
 	php_request_shutdown(NULL);
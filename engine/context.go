@@ -11,12 +11,19 @@ package engine
 import "C"
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"unsafe"
 	"errors"
+	"io/fs"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Context represents an individual execution context.
@@ -27,15 +34,94 @@ type Context struct {
 	Output io.Writer
 	Log    io.Writer
 
-	// Http Input/Output
+	// The size of the buffer output produced by scripts is collected in before
+	// being written into Output, avoiding a separate write for every 'echo'.
+	// Buffered output is written once the buffer is full, when calling flush()
+	// in PHP, and when the request is finished or shut down. Output is written
+	// immediately if unset.
+	OutputBufferSize int
+
+	// Http Input/Output. Responses are sent with the standard reason phrase for
+	// the status code, as reason phrases set by scripts are not preserved.
 	ResponseWriter http.ResponseWriter
 	Request *http.Request
 
+	// Response holds the status and headers set by scripts for contexts without
+	// a ResponseWriter, and is available once the request has been started.
+	Response *Response
+
 	// Other variables in $_SERVER
 	DocumentRoot string
 	ScriptFileName string
+	PathInfo string
+
+	// The maximum size of request bodies read by PHP, in bytes. Requests with
+	// a larger Content-Length fail to start with ErrBodyTooLarge, as do requests
+	// with larger form data or file uploads, which are read on startup. Scripts
+	// reading larger bodies via 'php://input' see the body end early, while the
+	// response is replaced with a '413 Request Entity Too Large' response, unless
+	// already started, and Exec returns ErrBodyTooLarge. Unlimited if unset.
+	MaxBodyBytes int64
+
+	// Uploads, if set, causes multipart form data to be parsed in Go rather than
+	// in PHP, see UploadConfig.
+	Uploads *UploadConfig
+
+	// Proxies trusted to report the original client address, scheme and host
+	// via the 'Forwarded' or 'X-Forwarded-*' headers. These are used for the
+	// REMOTE_ADDR, HTTPS, SERVER_PORT and HTTP_HOST variables in $_SERVER for
+	// requests received from trusted proxies, see ParseTrustedProxies.
+	TrustedProxies []*net.IPNet
+
+	// Determines whether request headers with underscores in their names are
+	// registered in $_SERVER, see UnderscoreHeaderPolicy.
+	UnderscoreHeaders UnderscoreHeaderPolicy
+
+	// OnServerValues is called with the variables registered in $_SERVER for
+	// HTTP requests, and may be used for adding or overriding entries.
+	OnServerValues func(values map[string]interface{})
+
+	// OnSendHeaders is called with the response status and headers for HTTP
+	// requests before these are sent, and may be used for modifying headers or
+	// changing the status, by returning a status code other than zero. Returning
+	// an error replaces the response with an empty '500 Internal Server Error'
+	// response, and discards any output produced by the script.
+	OnSendHeaders func(status int, header http.Header) (int, error)
+
+	// OnFinishRequest is called once the script completes the response via
+	// fastcgi_finish_request(), after which the script may continue running in
+	// the background. Any further output is discarded.
+	OnFinishRequest func()
+
+	// The maximum time scripts may continue running after completing the
+	// response via fastcgi_finish_request(), after which they are terminated as
+	// if exceeding 'max_execution_time'. Unlimited if unset. Scripts can only be
+	// interrupted from other threads as of PHP 7.1, so this is ignored for
+	// earlier versions, where scripts run in the background for as long as they
+	// need to.
+	MaxBackgroundTime time.Duration
+
+	context  *C.struct__engine_context
+	thread   *thread
+	fs       fs.FS
+	programs map[*Program]*C.zend_op_array
+	worker   *workerState
+	body     *requestBody
+	form     *form
+	uploads  *form
+	output   *bufio.Writer
+	writer   http.ResponseWriter
 
-	context *C.struct__engine_context
+	// Set once the final response status and headers have been sent, and if
+	// the response has been vetoed by OnSendHeaders, respectively.
+	wroteHeader bool
+	discard     bool
+
+	// Closed once the response has been completed, and the timer terminating
+	// the script once exceeding MaxBackgroundTime, if any.
+	finished  chan struct{}
+	timer     *time.Timer
+	timerLock sync.Mutex
 }
 
 // Bind allows for binding Go values into the current execution context under
@@ -43,17 +129,63 @@ type Context struct {
 // (check the documentation for NewValue for what is considered to be a "valid"
 // value).
 func (c *Context) Bind(name string, val interface{}) error {
-	v, err := NewValue(val)
+	n := C.CString(name)
+	defer C.free(unsafe.Pointer(n))
+
+	var err error
+	c.thread.call(func() {
+		var v *C.struct__zval_struct
+		if v, err = newValue(val); err == nil {
+			C.context_bind(c.context, n, v)
+		}
+	})
+
+	return err
+}
+
+// Global returns a copy of the global variable for the name given, and whether
+// the variable is set. Values returned are owned by the caller, and must be
+// released with DestroyValue.
+func (c *Context) Global(name string) (*C.struct__zval_struct, bool) {
+	n := C.CString(name)
+	defer C.free(unsafe.Pointer(n))
+
+	var result C.struct__zval_struct
+	var err error
+	c.thread.call(func() {
+		result, err = C.context_global(c.context, n)
+	})
+
 	if err != nil {
-		return err
+		return nil, false
 	}
+	return &result, true
+}
 
+// Unset removes the global variable for the name given, if set.
+func (c *Context) Unset(name string) {
 	n := C.CString(name)
 	defer C.free(unsafe.Pointer(n))
 
-	C.context_bind(c.context, n, v)
+	c.thread.call(func() {
+		C.context_unset(c.context, n)
+	})
+}
 
-	return nil
+// Globals returns the names of all global variables set in the context, in the
+// order they were defined. Superglobals such as $_SERVER are not included.
+func (c *Context) Globals() []string {
+	var names []string
+	c.thread.call(func() {
+		v := C.context_globals(c.context)
+		defer destroyValue(&v)
+
+		for _, n := range toSlice(&v) {
+			names = append(names, n.(string))
+		}
+	})
+
+	return names
 }
 
 // Exec executes a PHP script pointed to by filename in the current execution
@@ -63,23 +195,102 @@ func (c *Context) Exec(filename string) error {
 	f := C.CString(filename)
 	defer C.free(unsafe.Pointer(f))
 
-	_, err := C.context_exec(c.context, f)
+	var err error
+	c.thread.call(func() {
+		// Scripts on disk include files from disk, see ExecFS.
+		c.fs = nil
+		c.context.uses_fs = 0
+		_, err = C.context_exec(c.context, f)
+	})
+
 	if err != nil {
 		return fmt.Errorf("Error executing script '%s' in context", filename)
 	}
+	if c.body != nil && c.body.exceeded {
+		return ErrBodyTooLarge
+	}
 	return nil
 }
 
+// ExecValue executes a PHP script pointed to by filename in the current execution
+// context, as is done by Exec, and returns the value returned by the script, as
+// is done for 'require' in PHP, e.g. for configuration files returning arrays.
+// Scripts not returning a value explicitly return 1.
+func (c *Context) ExecValue(filename string) (*C.struct__zval_struct, error) {
+	f := C.CString(filename)
+	defer C.free(unsafe.Pointer(f))
+
+	var result C.struct__zval_struct
+	var err error
+	c.thread.call(func() {
+		// Scripts on disk include files from disk, see ExecFS.
+		c.fs = nil
+		c.context.uses_fs = 0
+		result, err = C.context_exec_value(c.context, f)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Error executing script '%s' in context", filename)
+	}
+	if c.body != nil && c.body.exceeded {
+		c.thread.call(func() { destroyValue(&result) })
+		return nil, ErrBodyTooLarge
+	}
+	return &result, nil
+}
+
 // Eval executes the PHP expression contained in script, and returns a Value
 // containing the PHP value returned by the expression, if any. Any output
 // produced is written context's pre-defined io.Writer instance.
 func (c *Context) Eval(script string) (*C.struct__zval_struct, error) {
+	result, err := c.eval("gophp-engine", 1, script)
+	if err != nil {
+		return nil, fmt.Errorf("Error executing script '%s' in context", script)
+	}
+	if c.body != nil && c.body.exceeded {
+		c.thread.call(func() { destroyValue(result) })
+		return nil, ErrBodyTooLarge
+	}
+	return result, nil
+}
+
+// EvalNamed executes the PHP expression contained in script, as is done by Eval,
+// compiled under the filename passed and starting from the line passed. Errors,
+// stack traces and constants such as __FILE__ and __LINE__ therefore refer to
+// the original source of the expression, e.g. a template stored elsewhere.
+func (c *Context) EvalNamed(filename string, line int, script string) (*C.struct__zval_struct, error) {
+	result, err := c.eval(filename, line, script)
+	if err != nil {
+		return nil, fmt.Errorf("Error executing script '%s' in context", filename)
+	}
+	if c.body != nil && c.body.exceeded {
+		c.thread.call(func() { destroyValue(result) })
+		return nil, ErrBodyTooLarge
+	}
+	return result, nil
+}
+
+func (c *Context) eval(filename string, line int, script string) (*C.struct__zval_struct, error) {
+	// Expressions are always compiled starting from the first line, so any
+	// preceding lines are padded.
+	if line > 1 {
+		script = strings.Repeat("\n", line-1) + script
+	}
+
 	s := C.CString(script)
 	defer C.free(unsafe.Pointer(s))
 
-	result, err := C.context_eval(c.context, s)
+	f := C.CString(filename)
+	defer C.free(unsafe.Pointer(f))
+
+	var result C.struct__zval_struct
+	var err error
+	c.thread.call(func() {
+		result, err = C.context_eval(c.context, s, f)
+	})
+
 	if err != nil {
-		return nil, fmt.Errorf("Error executing script '%s' in context", script)
+		return nil, err
 	}
 	return &result, nil
 }
@@ -97,6 +308,77 @@ func (ctx *Context) FinishRequest() error {
 	}
 }
 
+// Finished returns a channel which is closed once the script completes the
+// response via fastcgi_finish_request(), while possibly continuing to run.
+func (c *Context) Finished() <-chan struct{} {
+	return c.finished
+}
+
+// Set if scripts can be interrupted from other threads, as is needed for
+// terminating scripts exceeding MaxBackgroundTime.
+const contextInterrupt = C.CONTEXT_INTERRUPT == 1
+
+// finish marks the response as completed, and starts the timer for terminating
+// the script once exceeding MaxBackgroundTime, if set.
+func (c *Context) finish() {
+	c.discard = true
+	c.detach()
+	close(c.finished)
+
+	if c.OnFinishRequest != nil {
+		c.OnFinishRequest()
+	}
+
+	if c.MaxBackgroundTime > 0 && contextInterrupt {
+		c.timerLock.Lock()
+		c.timer = time.AfterFunc(c.MaxBackgroundTime, c.interrupt)
+		c.timerLock.Unlock()
+	}
+}
+
+// detach replaces the HTTP response writer and request used by the context with
+// a discarding writer and a copy of the request without a body, as neither may
+// be used once the handler serving the request returns, while the script may
+// continue running in the background. Any unread body is discarded rather than
+// read, which would hold up completing the response for slow or large bodies.
+func (c *Context) detach() {
+	if c.ResponseWriter != nil {
+		w := discardWriter{c.writer.Header().Clone()}
+		c.ResponseWriter, c.writer, c.Output = w, w, w
+	}
+
+	if c.Request != nil {
+		c.Request = c.Request.Clone(context.Background())
+		c.Request.Body = http.NoBody
+	}
+
+	if c.body != nil {
+		c.body.discard()
+	}
+}
+
+// interrupt terminates the script running in the background.
+func (c *Context) interrupt() {
+	c.timerLock.Lock()
+	defer c.timerLock.Unlock()
+
+	if c.timer != nil {
+		C.context_interrupt(c.context)
+	}
+}
+
+// stopBackground stops the timer for terminating the script, if any, which must
+// not fire once the request is being shut down.
+func (c *Context) stopBackground() {
+	c.timerLock.Lock()
+	defer c.timerLock.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
 type evalAssertionArg struct {
 	val *C.struct__zval_struct
 }
@@ -19,12 +19,11 @@ func TestContextNew(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewContext(): %s", err)
 	}
+	defer RequestShutdown(c)
 
 	if c.context == nil || c.ResponseWriter == nil {
 		t.Fatalf("NewContext(): Struct fields are `nil` but no error returned")
 	}
-
-	RequestShutdown(c)
 }
 
 var execTests = []struct {
@@ -78,6 +77,102 @@ func TestContextExec(t *testing.T) {
 	RequestShutdown(c)
 }
 
+var execValueTests = []struct {
+	name   string
+	script string
+	value  interface{}
+}{
+	{"config.php", "<?php return ['name' => 'go-php', 'workers' => 4];", map[string]interface{}{"name": "go-php", "workers": int64(4)}},
+	{"list.php", "<?php return [1, 2, 3];", []interface{}{int64(1), int64(2), int64(3)}},
+	{"implicit.php", "<?php $a = 1;", int64(1)},
+}
+
+func TestContextExecValue(t *testing.T) {
+	Initialize()
+	c := &Context{}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	for _, tt := range execValueTests {
+		script, err := NewScript(tt.name, tt.script)
+		if err != nil {
+			t.Errorf("Could not create temporary file '%s' for testing: %s", tt.name, err)
+			continue
+		}
+
+		val, err := c.ExecValue(script.Name())
+		script.Remove()
+
+		if err != nil {
+			t.Errorf("Context.ExecValue('%s'): Execution failed: %s", tt.name, err)
+			continue
+		}
+
+		if result := ToInterface(val); !reflect.DeepEqual(result, tt.value) {
+			t.Errorf("Context.ExecValue('%s'): Expected value '%#v', actual '%#v'", tt.name, tt.value, result)
+		}
+
+		DestroyValue(val)
+	}
+
+	if _, err := c.ExecValue("/missing/script.php"); err == nil {
+		t.Errorf("Context.ExecValue(): expected error for missing script")
+	}
+}
+
+var globalsScript = `<?php
+$result = ['status' => 'ok', 'count' => 3];
+$total = 42;
+$unused = null;
+unset($unused);`
+
+func TestContextGlobals(t *testing.T) {
+	Initialize()
+	c := &Context{}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	script, err := NewScript("globals.php", globalsScript)
+	if err != nil {
+		t.Fatalf("Could not create temporary file for testing: %s", err)
+	}
+	defer script.Remove()
+
+	if err := c.Exec(script.Name()); err != nil {
+		t.Fatalf("Context.Exec(): Execution failed: %s", err)
+	}
+
+	if names := c.Globals(); !reflect.DeepEqual(names, []string{"result", "total"}) {
+		t.Errorf("Context.Globals(): Expected '[result total]', actual '%v'", names)
+	}
+
+	val, ok := c.Global("result")
+	if !ok {
+		t.Fatalf("Context.Global('result'): Expected variable to be set")
+	}
+
+	expected := map[string]interface{}{"status": "ok", "count": int64(3)}
+	if result := ToInterface(val); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Context.Global('result'): Expected value '%#v', actual '%#v'", expected, result)
+	}
+
+	DestroyValue(val)
+
+	if _, ok := c.Global("unused"); ok {
+		t.Errorf("Context.Global('unused'): Expected unset variable to be missing")
+	}
+
+	c.Unset("total")
+
+	if _, ok := c.Global("total"); ok {
+		t.Errorf("Context.Unset('total'): Expected variable to be removed")
+	}
+
+	if names := c.Globals(); !reflect.DeepEqual(names, []string{"result"}) {
+		t.Errorf("Context.Unset('total'): Expected globals '[result]', actual '%v'", names)
+	}
+}
+
 var evalTests = []struct {
 	script string
 	output string
@@ -130,6 +225,38 @@ func TestContextEval(t *testing.T) {
 	RequestShutdown(c)
 }
 
+var evalNamedTests = []struct {
+	filename string
+	line     int
+	script   string
+	value    interface{}
+}{
+	{"template.php", 1, "return __FILE__ . ':' . __LINE__;", "template.php:1"},
+	{"rules/42", 10, "$a = 1;\nreturn __FILE__ . ':' . __LINE__;", "rules/42:11"},
+	{"trace.php", 5, "try { throw new Exception(); } catch (Exception $e) { return $e->getFile() . ':' . $e->getLine(); }", "trace.php:5"},
+}
+
+func TestContextEvalNamed(t *testing.T) {
+	Initialize()
+	c := &Context{}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	for _, tt := range evalNamedTests {
+		val, err := c.EvalNamed(tt.filename, tt.line, tt.script)
+		if err != nil {
+			t.Errorf("Context.EvalNamed('%s'): %s", tt.filename, err)
+			continue
+		}
+
+		if result := ToInterface(val); result != tt.value {
+			t.Errorf("Context.EvalNamed('%s'): Expected value '%#v', actual '%#v'", tt.filename, tt.value, result)
+		}
+
+		DestroyValue(val)
+	}
+}
+
 var logTests = []struct {
 	script   string
 	expected string
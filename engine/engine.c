@@ -3,7 +3,9 @@
 // the LICENSE file.
 
 #include <stdio.h>
+#include <stdlib.h>
 #include <errno.h>
+#include <strings.h>
 
 #include <main/php.h>
 #include <main/SAPI.h>
@@ -13,15 +15,22 @@
 
 #include "context.h"
 #include "engine.h"
+#include "worker.h"
+#include "cache.h"
+#include "session.h"
+#include "fs.h"
+#include "lint.h"
 #include "_cgo_export.h"
 
-// The php.ini defaults for the Go-PHP engine.
+// The php.ini defaults for the Go-PHP engine. Implicit flushing is disabled, as
+// flushing sends output to the client right away, which is only done when
+// scripts call flush().
 const char engine_ini_defaults[] = {
 	"expose_php = 0\n"
 	"default_mimetype =\n"
 	"html_errors = 0\n"
 	"register_argc_argv = 1\n"
-	"implicit_flush = 1\n"
+	"implicit_flush = 0\n"
 	"output_buffering = 0\n"
 	"max_execution_time = 0\n"
 	"opcache.enable = 1\n"
@@ -32,6 +41,21 @@ const char engine_ini_defaults[] = {
 };
 static zend_module_entry engine_module_entry;
 
+// Defaults for settings which may be overridden via php.ini, unlike those set
+// in engine_ini_defaults.
+static void engine_ini_config_defaults(HashTable *configuration_hash) {
+	zval value;
+
+	// Sessions are handled by the Go session handler, which passes them on to
+	// the built-in 'files' handler unless a store is set via SetSessionHandler.
+	ZVAL_NEW_STR(&value, zend_string_init("go", sizeof("go") - 1, 1));
+	zend_hash_str_update(configuration_hash, "session.save_handler", sizeof("session.save_handler") - 1, &value);
+}
+
+// Set for threads started via engine_thread_startup, which are allowed to call
+// into PHP directly.
+static __thread int engine_thread_started = 0;
+
 static int engine_ub_write(const char *str, uint len) {
 	engine_context *context = SG(server_context);
 
@@ -46,6 +70,27 @@ static int engine_ub_write(const char *str, uint len) {
 static int engine_header_handler(sapi_header_struct *sapi_header, sapi_header_op_enum op, sapi_headers_struct *sapi_headers) {
 	engine_context *context = SG(server_context);
 
+	// CGI-style 'Status' headers set the response status, as is done for headers
+	// of the form 'HTTP/1.1 404 Not Found', and are not sent as-is.
+	if (op != SAPI_HEADER_DELETE && strncasecmp(sapi_header->header, "Status:", 7) == 0) {
+		char *status = sapi_header->header + 7;
+		while (*status == ' ' || *status == '\t') {
+			status++;
+		}
+
+		int code = atoi(status);
+		if (code >= 100 && code <= 999) {
+			if (sapi_headers->http_status_line) {
+				efree(sapi_headers->http_status_line);
+			}
+
+			sapi_headers->http_response_code = code;
+			spprintf(&sapi_headers->http_status_line, 0, "HTTP/1.1 %s", status);
+		}
+
+		return 0;
+	}
+
 	switch (op) {
 	case SAPI_HEADER_REPLACE:
 	case SAPI_HEADER_ADD:
@@ -57,17 +102,58 @@ static int engine_header_handler(sapi_header_struct *sapi_header, sapi_header_op
 	return 0;
 }
 
+static void engine_flush(void *server_context) {
+	if (server_context == NULL) {
+		return;
+	}
+
+	// Headers are sent ahead of flushing, even if no output has been produced.
+	if (!SG(headers_sent)) {
+		sapi_send_headers();
+	}
+
+	engineFlush(server_context);
+}
+
 static int engine_send_headers(sapi_headers_struct *sapi_headers) {
 	if (SG(request_info).no_headers == 1) {
     		return  SAPI_HEADER_SENT_SUCCESSFULLY;
 	}
 	engine_context *context = SG(server_context);
-	engineSendHeaders(context, SG(sapi_headers).http_response_code);
+	int code = sapi_headers->http_response_code;
+	engineSendHeaders(context, code, sapi_headers->http_status_line);
+
+	// Informational responses, e.g. '103 Early Hints', are followed by the final
+	// response, which is sent once output is produced or flush() is called.
+	if (code >= 100 && code < 200 && code != 101) {
+		sapi_headers->http_response_code = 200;
+		SG(headers_sent) = 0;
+	}
+
 	return  SAPI_HEADER_SENT_SUCCESSFULLY;
 }
 
 static size_t engine_read_post(char *buffer, size_t count_bytes) {
-	return engineReadPost(SG(server_context), buffer, count_bytes);
+	int read = engineReadPost(SG(server_context), buffer, count_bytes);
+	if (read <= 0) {
+		return 0;
+	}
+
+	// Multipart bodies are consumed by the file upload handler directly, and are
+	// stored here so that they remain readable via 'php://input', as is the case
+	// for other request bodies.
+	const char *content_type = SG(request_info).content_type;
+	if (content_type && strncasecmp(content_type, "multipart/form-data", 19) == 0) {
+		if (SG(request_info).request_body == NULL) {
+			SG(request_info).request_body = php_stream_temp_create_ex(TEMP_STREAM_DEFAULT, SAPI_POST_BLOCK_SIZE, PG(upload_tmp_dir));
+		}
+
+		if (SG(request_info).request_body != NULL) {
+			php_stream_write(SG(request_info).request_body, buffer, read);
+		}
+	}
+
+	return read;
 }
 
 static char *engine_read_cookies() {
@@ -101,12 +187,35 @@ PHP_FUNCTION(fastcgi_finish_request) /* {{{ */
 	}
 	sapi_send_headers();
 	php_output_end_all();
+	sapi_flush();
 	context->is_finished = 1;
+	engineFinishRequest(context);
 	RETURN_TRUE;
 }
 
+PHP_FUNCTION(getallheaders) /* {{{ */
+{
+	if (zend_parse_parameters_none() == FAILURE) {
+		return;
+	}
+
+	zval headers = engineRequestHeaders(SG(server_context));
+	RETVAL_ZVAL(&headers, 0, 0);
+}
+
 static const zend_function_entry engine_sapi_functions[] = {
 	PHP_FE(fastcgi_finish_request,              NULL)
+	PHP_FE(getallheaders,                       NULL)
+	PHP_FALIAS(apache_request_headers, getallheaders, NULL)
+	PHP_FE(go_handle_request,                   NULL)
+	PHP_FE(go_cache_get,                        NULL)
+	PHP_FE(go_cache_set,                        NULL)
+	PHP_FE(go_cache_add,                        NULL)
+	PHP_FE(go_cache_exists,                     NULL)
+	PHP_FE(go_cache_delete,                     NULL)
+	PHP_FE(go_cache_inc,                        NULL)
+	PHP_FE(go_cache_cas,                        NULL)
+	PHP_FE(go_cache_clear,                      NULL)
 	{NULL, NULL, NULL}
 };
 
@@ -121,7 +230,7 @@ static sapi_module_struct engine_module = {
 	NULL,                        // Deactivate
 
 	_engine_ub_write,            // Unbuffered Write
-	NULL,                        // Flush
+	engine_flush,                // Flush
 	NULL,                        // Get UID
 	NULL,                        // Getenv
 
@@ -153,13 +262,24 @@ php_engine *engine_init(char *php_ini_path_override) {
 		#endif
 	#endif
 
+	#ifdef ZTS
+		tsrm_startup(1, 1, 0, NULL);
+		ts_resource(0);
+		ZEND_TSRMLS_CACHE_UPDATE();
+	#endif
+
 	sapi_startup(&engine_module);
 
 	engine_module.ini_entries = malloc(sizeof(engine_ini_defaults));
 	memcpy(engine_module.ini_entries, engine_ini_defaults, sizeof(engine_ini_defaults));
 	engine_module.additional_functions = engine_sapi_functions;
+	engine_module.ini_defaults = engine_ini_config_defaults;
 	engine_module.php_ini_path_override = php_ini_path_override;
 
+	// The Go session handler is registered ahead of the session module reading
+	// its settings, and for all threads at once.
+	session_init();
+
 	if (php_module_startup(&engine_module, NULL, 0) == FAILURE) {
 		sapi_shutdown();
 
@@ -179,6 +299,9 @@ php_engine *engine_init(char *php_ini_path_override) {
         zend_append_version_info(accel_extension);
     }
 
+	fs_init();
+	lint_init();
+
 	engine = malloc((sizeof(php_engine)));
 
 	errno = 0;
@@ -189,8 +312,48 @@ void engine_shutdown(php_engine *engine) {
 	php_module_shutdown();
 	sapi_shutdown();
 
+	#ifdef ZTS
+		tsrm_shutdown();
+	#endif
+
 	free(engine_module.ini_entries);
 	free(engine);
 }
 
+// Returns 1 if the engine is built against a thread-safe (ZTS) build of PHP, in
+// which case requests may be run on multiple threads concurrently.
+int engine_thread_safe() {
+	#ifdef ZTS
+		return 1;
+	#else
+		return 0;
+	#endif
+}
+
+// Prepares the calling thread for running requests. For thread-safe builds, this
+// allocates the PHP globals for the thread, and must be called on every thread
+// other than the one the engine was initialized on.
+void engine_thread_startup() {
+	#ifdef ZTS
+		ts_resource(0);
+		ZEND_TSRMLS_CACHE_UPDATE();
+	#endif
+
+	engine_thread_started = 1;
+}
+
+// Frees any resources allocated for the calling thread by engine_thread_startup.
+void engine_thread_shutdown() {
+	#ifdef ZTS
+		ts_free_thread();
+	#endif
+
+	engine_thread_started = 0;
+}
+
+// Returns 1 if the calling thread has been prepared for running requests.
+int engine_thread_current() {
+	return engine_thread_started;
+}
+
 #include "_engine.c"
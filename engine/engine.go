@@ -16,11 +16,11 @@ import "C"
 import (
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"unsafe"
 	"errors"
-	"strconv"
-	"path/filepath"
+	"sync"
 )
 
 // Engine represents the core PHP engine bindings.
@@ -28,15 +28,45 @@ type Engine struct {
 	engine    *C.struct__php_engine
 	contexts  map[*C.struct__engine_context]*Context
 	receivers map[string]*Receiver
+	pools     []*WorkerPool
+	workers   []*Worker
+	sessions  SessionStore
+	cache     *Cache
+	wrappers  map[string]StreamWrapper
+	streams   map[C.ulong]*streamHandle
+	streamID  C.ulong
+	constants map[string]interface{}
+
+	// Protects the maps above, which are accessed concurrently by worker pool
+	// threads in thread-safe builds.
+	lock sync.RWMutex
+
+	// Held while starting worker pools and workers, and while registering stream
+	// wrappers, which modifies state shared by all threads without locking, and
+	// is therefore only allowed while no other threads are running.
+	threads sync.Mutex
+
+	// The engine thread all calls into PHP are made on, and a single-slot
+	// queue holding the thread while no request is active on it.
+	thread *thread
+	idle   chan *thread
 }
 
 // This contains a reference to the active engine, if any.
 var engine *Engine
+var initialize sync.Mutex
 var PHP_INI_PATH_OVERRIDE string
 
 // New initializes a PHP engine instance on which contexts can be executed. It
 // corresponds to PHP's MINIT (module init) phase.
+//
+// The engine is started on a dedicated OS thread, onto which all subsequent
+// calls are marshalled. It is therefore safe to use the engine from any
+// goroutine.
 func Initialize() error {
+	initialize.Lock()
+	defer initialize.Unlock()
+
 	if engine != nil {
 		return fmt.Errorf("Cannot activate multiple engine instances")
 	}
@@ -45,96 +75,147 @@ func Initialize() error {
 	if PHP_INI_PATH_OVERRIDE != "" {
 		phpInitPathOverride = C.CString(PHP_INI_PATH_OVERRIDE)
 	}
-	ptr, err := C.engine_init(phpInitPathOverride)
-	if err != nil {
-		return fmt.Errorf("PHP engine failed to initialize")
-	}
 
-	engine = &Engine{
-		engine:    ptr,
+	e := &Engine{
 		contexts:  make(map[*C.struct__engine_context]*Context),
 		receivers: make(map[string]*Receiver),
+		wrappers:  make(map[string]StreamWrapper),
+		streams:   make(map[C.ulong]*streamHandle),
+		constants: make(map[string]interface{}),
+		idle:      make(chan *thread, 1),
 	}
 
+	t, err := newThread(func() error {
+		ptr, err := C.engine_init(phpInitPathOverride)
+		if err == nil {
+			C.engine_thread_startup()
+		}
+		if err != nil {
+			return fmt.Errorf("PHP engine failed to initialize")
+		}
+
+		e.engine = ptr
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.thread = t
+	e.idle <- t
+	engine = e
+
 	return nil
 }
 
 // NewContext creates a new execution context for the active engine and returns
 // an error if the execution context failed to initialize at any point. This
 // corresponds to PHP's RINIT (request init) phase.
+//
+// Only a single request may be active on the engine at any time. Calling
+// RequestStartup while another context is active blocks until that context is
+// shut down via RequestShutdown.
 func RequestStartup(ctx *Context) error {
+	if err := prepareBody(ctx); err != nil {
+		return err
+	}
+
+	t := <-engine.idle
+
+	var err error
+	t.call(func() {
+		err = requestStartup(ctx, t)
+	})
+
+	if err != nil {
+		engine.idle <- t
+		ctx.form.remove()
+		return err
+	}
+
+	ctx.thread = t
+	return nil
+}
+
+// requestStartup starts a request for the context passed on thread t, which must
+// be the calling thread, after applying any changes scheduled for the thread.
+func requestStartup(ctx *Context, t *thread) error {
+	t.applyPending()
+
+	ptr, err := newContext(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = C.context_startup(ptr)
+	if err != nil {
+		// context is freed by context_startup on failure
+		engine.lock.Lock()
+		delete(engine.contexts, ptr)
+		engine.lock.Unlock()
+		ctx.context = nil
+		return fmt.Errorf("failed to startup context: %s", err.Error())
+	}
+	// Form data and file uploads are read in full on startup.
+	if ctx.body != nil && ctx.body.exceeded {
+		requestShutdown(ctx)
+		return ErrBodyTooLarge
+	}
+	if ctx.body != nil {
+		ctx.body.reject = true
+	}
+	if ctx.form != nil {
+		ctx.form.register(ptr)
+		ctx.uploads, ctx.form = ctx.form, nil
+	}
+	if err = defineConstants(); err != nil {
+		requestShutdown(ctx)
+		return err
+	}
+	return nil
+}
+
+// newContext creates and registers the engine context for the context passed,
+// ahead of starting the request.
+func newContext(ctx *Context) (*C.struct__engine_context, error) {
+	if ctx.ResponseWriter != nil {
+		if ctx.Output != nil {
+			return nil, errors.New("can not set Output when ResponseWriter is specified")
+		}
+		ctx.Output = ctx.ResponseWriter
+	}
+	ctx.output = newOutput(ctx)
+	ctx.writer, ctx.Response = ctx.ResponseWriter, nil
+	if ctx.writer == nil {
+		ctx.Response = &Response{Header: make(http.Header)}
+		ctx.writer = responseRecorder{ctx.Response}
+	}
+	ctx.wroteHeader, ctx.discard = false, false
+	ctx.finished = make(chan struct{})
 	var serverValues *C.struct__zval_struct
 	if ctx.Request != nil {
-		scriptName, err := filepath.Rel(ctx.DocumentRoot, ctx.ScriptFileName)
+		var err error
+		serverValues, err = newValue(serverVariables(ctx))
 		if err != nil {
-			scriptName = ""
-		} else {
-			scriptName = "/" + scriptName
-		}
-		remoteAddrParts := strings.SplitN(ctx.Request.RemoteAddr, ":", 2)
-		remoteAddr := remoteAddrParts[0]
-		remotePort := ""
-		if len(remoteAddrParts) == 2 {
-			remotePort = remoteAddrParts[1]
-		}
-		httpHostParts := strings.SplitN(ctx.Request.Host, ":", 2)
-		serverName := httpHostParts[0]
-		serverPort := ""
-		if len(httpHostParts) == 2 {
-			serverPort = httpHostParts[1]
-		}
-		serverValues_ := map[string]interface{}{
-			"REQUEST_URI": ctx.Request.RequestURI,
-			"QUERY_STRING": ctx.Request.URL.RawQuery,
-			"REQUEST_METHOD": ctx.Request.Method,
-			"DOCUMENT_ROOT": ctx.DocumentRoot,
-			"SCRIPT_FILENAME": ctx.ScriptFileName,
-			"SCRIPT_NAME": scriptName,
-			"PHP_SELF": scriptName,
-			"REMOTE_ADDR": remoteAddr,
-			"REMOTE_PORT": remotePort,
-			"HTTP_HOST": ctx.Request.Host,
-			"SERVER_NAME": serverName,
-			"SERVER_PORT": serverPort,
-		}
-		for k, v := range ctx.Request.Header {
-			serverValues_["HTTP_" + strings.Replace(strings.ToUpper(k), "-", "_", -1)] = v[0]
-		}
-		contentLength, isContentLengthSet := serverValues_["HTTP_CONTENT_LENGTH"].(string)
-		if isContentLengthSet {
-			contentLengthAsInt, err := strconv.Atoi(contentLength)
-			if err != nil {
-				serverValues_["HTTP_CONTENT_LENGTH"] = 0
-			} else {
-				serverValues_["HTTP_CONTENT_LENGTH"] = contentLengthAsInt
-			}
-		}
-		serverValues, err = NewValue(serverValues_)
-		if err != nil {
-			return errors.New(fmt.Sprintf("failed to create server values: %s", err.Error()))
+			return nil, errors.New(fmt.Sprintf("failed to create server values: %s", err.Error()))
 		}
 	}
 	ptr, err := C.context_new(serverValues)
 	if err != nil {
 		// serverValues is not owned by context now, need to free it here
-		DestroyValue(serverValues)
-		return fmt.Errorf("failed to new context: %s", err.Error())
+		destroyValue(serverValues)
+		return nil, fmt.Errorf("failed to new context: %s", err.Error())
 	}
 	// passed serverValues ownership to context
 	ctx.context = ptr
 	// Store reference to context, using pointer as key.
+	engine.lock.Lock()
 	engine.contexts[ptr] = ctx
-	if ctx.ResponseWriter != nil {
-		if ctx.Output != nil {
-			return errors.New("can not set Output when ResponseWriter is specified")
-		}
-		ctx.Output = ctx.ResponseWriter
-	}
-	_, err = C.context_startup(ptr)
-	if err != nil {
-		return fmt.Errorf("failed to startup context: %s", err.Error())
+	engine.lock.Unlock()
+	if ctx.form != nil {
+		// The request body has already been parsed, see UploadConfig.
+		C.context_skip_post_data(ptr)
 	}
-	return nil
+	return ptr, nil
 }
 
 // Destroy tears down the current execution context
@@ -143,9 +224,29 @@ func RequestShutdown(ctx *Context) {
 	if ctx.context == nil {
 		return
 	}
-	delete(engine.contexts, ctx.context)
+	ctx.thread.call(func() {
+		requestShutdown(ctx)
+	})
+	engine.idle <- ctx.thread
+	ctx.thread = nil
+}
+
+func requestShutdown(ctx *Context) {
+	ctx.stopBackground()
+	ctx.destroyPrograms()
+	// Remaining output and headers are sent while the request is shut down, so
+	// the context is only removed afterwards.
 	C.context_destroy(ctx.context)
+	engine.lock.Lock()
+	delete(engine.contexts, ctx.context)
+	engine.lock.Unlock()
 	ctx.context = nil
+	ctx.uploads.release()
+	ctx.uploads = nil
+	if ctx.output != nil {
+		ctx.output.Flush()
+		ctx.output = nil
+	}
 }
 
 // Define registers a PHP class for the name passed, using function fn as
@@ -157,7 +258,9 @@ func RequestShutdown(ctx *Context) {
 // context, and should return a method receiver instance, or nil on error (in
 // which case, an exception is thrown on the PHP object constructor).
 func Define(name string, fn func(args []interface{}) interface{}) error {
+	engine.lock.Lock()
 	if _, exists := engine.receivers[name]; exists {
+		engine.lock.Unlock()
 		return fmt.Errorf("Failed to define duplicate receiver '%s'", name)
 	}
 
@@ -167,22 +270,94 @@ func Define(name string, fn func(args []interface{}) interface{}) error {
 		objects: make(map[*C.struct__engine_receiver]*ReceiverObject),
 	}
 
-	n := C.CString(name)
-	defer C.free(unsafe.Pointer(n))
-
-	C.receiver_define(n)
 	engine.receivers[name] = rcvr
+	engine.lock.Unlock()
+
+	// Classes are registered separately for each thread in thread-safe builds,
+	// ahead of the next request started on each.
+	engine.each(func() {
+		n := C.CString(name)
+		defer C.free(unsafe.Pointer(n))
+
+		C.receiver_define(n)
+	})
 
 	return nil
 }
 
+// call runs fn on the calling thread if it is an engine thread, otherwise on the
+// main engine thread. Values returned by PHP are bound to the thread they were
+// created on, and can only be operated on by that thread.
+func (e *Engine) call(fn func()) {
+	if C.engine_thread_current() == 1 {
+		fn()
+		return
+	}
+
+	e.thread.call(fn)
+}
+
+// each applies fn on the main engine thread and on all worker pool and worker
+// threads, ahead of the next request started on each. Threads may be busy
+// running requests for arbitrarily long, so fn is not run by the time each
+// returns. Worker threads only apply fn once their worker script restarts.
+func (e *Engine) each(fn func()) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	e.thread.schedule(fn)
+	for _, p := range e.pools {
+		for _, t := range p.threads {
+			t.schedule(fn)
+		}
+	}
+
+	for _, w := range e.workers {
+		w.thread.schedule(fn)
+	}
+}
+
+// context returns the Go context for the engine context passed, or nil if no
+// such context is active.
+func (e *Engine) context(ptr *C.struct__engine_context) *Context {
+	if e == nil {
+		return nil
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.contexts[ptr]
+}
+
+// object returns the method receiver instance attached to the PHP object
+// passed, or nil if no such instance exists.
+func (e *Engine) object(rcvr *C.struct__engine_receiver) *ReceiverObject {
+	if e == nil {
+		return nil
+	}
+
+	n := C.GoString(C._receiver_get_name(rcvr))
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.receivers[n] == nil {
+		return nil
+	}
+
+	return e.receivers[n].objects[rcvr]
+}
+
 func write(w io.Writer, buffer unsafe.Pointer, length C.uint) C.int {
 	// Do not return error if writer is unavailable.
 	if w == nil {
 		return C.int(length)
 	}
 
-	written, err := w.Write(C.GoBytes(buffer, C.int(length)))
+	// The buffer is only valid for the duration of the call, and is therefore
+	// passed without copying, as writers may not retain it.
+	written, err := w.Write(unsafe.Slice((*byte)(buffer), int(length)))
 	if err != nil {
 		return -1
 	}
@@ -192,25 +367,42 @@ func write(w io.Writer, buffer unsafe.Pointer, length C.uint) C.int {
 
 //export engineWriteOut
 func engineWriteOut(ctx *C.struct__engine_context, buffer unsafe.Pointer, length C.uint) C.int {
-	if engine == nil || engine.contexts[ctx] == nil {
+	context := engine.context(ctx)
+	if context == nil {
 		return -1
 	}
 
-	return write(engine.contexts[ctx].Output, buffer, length)
+	// Output is only produced once headers have been sent, except following an
+	// informational response, in which case the final response is implied.
+	if !context.wroteHeader {
+		context.writeHeader(http.StatusOK, "")
+	}
+
+	if context.discard {
+		return C.int(length)
+	}
+
+	if context.output != nil {
+		return write(context.output, buffer, length)
+	}
+
+	return write(context.Output, buffer, length)
 }
 
 //export engineWriteLog
 func engineWriteLog(ctx *C.struct__engine_context, buffer unsafe.Pointer, length C.uint) C.int {
-	if engine == nil || engine.contexts[ctx] == nil {
+	context := engine.context(ctx)
+	if context == nil {
 		return -1
 	}
 
-	return write(engine.contexts[ctx].Log, buffer, length)
+	return write(context.Log, buffer, length)
 }
 
 //export engineSetHeader
 func engineSetHeader(ctx *C.struct__engine_context, operation C.uint, buffer unsafe.Pointer, length C.uint) {
-	if engine == nil || engine.contexts[ctx] == nil {
+	context := engine.context(ctx)
+	if context == nil {
 		return
 	}
 
@@ -221,11 +413,12 @@ func engineSetHeader(ctx *C.struct__engine_context, operation C.uint, buffer uns
 		split[i] = strings.TrimSpace(split[i])
 	}
 
-	context := engine.contexts[ctx]
-	if context.ResponseWriter == nil {
+	// Headers can no longer be changed once the response has been completed.
+	if context.discard {
 		return
 	}
-	httpHeader := context.ResponseWriter.Header()
+
+	httpHeader := context.writer.Header()
 	switch operation {
 	case 0: // Replace header.
 		if len(split) == 2 && split[1] != "" {
@@ -244,32 +437,43 @@ func engineSetHeader(ctx *C.struct__engine_context, operation C.uint, buffer uns
 
 //export engineReceiverNew
 func engineReceiverNew(rcvr *C.struct__engine_receiver, args *C.struct__zval_struct) C.int {
+	if engine == nil {
+		return 1
+	}
+
 	n := C.GoString(C._receiver_get_name(rcvr))
-	if engine == nil || engine.receivers[n] == nil {
+
+	engine.lock.RLock()
+	r := engine.receivers[n]
+	engine.lock.RUnlock()
+
+	if r == nil {
 		return 1
 	}
 
-	obj, err := engine.receivers[n].NewObject(ToSlice(args))
+	obj, err := r.NewObject(toSlice(args))
 	if err != nil {
 		return 1
 	}
 
-	engine.receivers[n].objects[rcvr] = obj
+	engine.lock.Lock()
+	r.objects[rcvr] = obj
+	engine.lock.Unlock()
 
 	return 0
 }
 
 //export engineReceiverGet
 func engineReceiverGet(rcvr *C.struct__engine_receiver, name *C.char) C.struct__zval_struct {
-	n := C.GoString(C._receiver_get_name(rcvr))
-	if engine == nil || engine.receivers[n].objects[rcvr] == nil {
-		zvalNull, _:= NewValue(nil)
+	obj := engine.object(rcvr)
+	if obj == nil {
+		zvalNull, _:= newValue(nil)
 		return *zvalNull
 	}
 
-	val, err := engine.receivers[n].objects[rcvr].Get(C.GoString(name))
+	val, err := obj.Get(C.GoString(name))
 	if err != nil {
-		zvalNull, _:= NewValue(nil)
+		zvalNull, _:= newValue(nil)
 		return *zvalNull
 	}
 
@@ -278,22 +482,22 @@ func engineReceiverGet(rcvr *C.struct__engine_receiver, name *C.char) C.struct__
 
 //export engineReceiverSet
 func engineReceiverSet(rcvr *C.struct__engine_receiver, name *C.char, val *C.struct__zval_struct) {
-	n := C.GoString(C._receiver_get_name(rcvr))
-	if engine == nil || engine.receivers[n].objects[rcvr] == nil {
+	obj := engine.object(rcvr)
+	if obj == nil {
 		return
 	}
 
-	engine.receivers[n].objects[rcvr].Set(C.GoString(name), ToInterface(val))
+	obj.Set(C.GoString(name), toInterface(val))
 }
 
 //export engineReceiverExists
 func engineReceiverExists(rcvr *C.struct__engine_receiver, name *C.char) C.int {
-	n := C.GoString(C._receiver_get_name(rcvr))
-	if engine == nil || engine.receivers[n].objects[rcvr] == nil {
+	obj := engine.object(rcvr)
+	if obj == nil {
 		return 0
 	}
 
-	if engine.receivers[n].objects[rcvr].Exists(C.GoString(name)) {
+	if obj.Exists(C.GoString(name)) {
 		return 1
 	}
 
@@ -302,16 +506,16 @@ func engineReceiverExists(rcvr *C.struct__engine_receiver, name *C.char) C.int {
 
 //export engineReceiverCall
 func engineReceiverCall(rcvr *C.struct__engine_receiver, name *C.char, args *C.struct__zval_struct) C.struct__zval_struct {
-	n := C.GoString(C._receiver_get_name(rcvr))
-	if engine == nil || engine.receivers[n].objects[rcvr] == nil {
-		zvalNull, _:= NewValue(nil)
+	obj := engine.object(rcvr)
+	if obj == nil {
+		zvalNull, _:= newValue(nil)
 		return *zvalNull
 	}
 
-	val := engine.receivers[n].objects[rcvr].Call(C.GoString(name), ToSlice(args))
+	val := obj.Call(C.GoString(name), toSlice(args))
 
 	if val == nil {
-		zvalNull, _:= NewValue(nil)
+		zvalNull, _:= newValue(nil)
 		return *zvalNull
 	}
 
@@ -319,29 +523,74 @@ func engineReceiverCall(rcvr *C.struct__engine_receiver, name *C.char, args *C.s
 }
 
 
+//export engineRequestHeaders
+func engineRequestHeaders(ctx *C.struct__engine_context) C.struct__zval_struct {
+	headers := make(map[string]string)
+	if context := engine.context(ctx); context != nil {
+		headers = requestHeaders(context)
+	}
+
+	val, _ := newValue(headers)
+	return *val
+}
+
 //export engineReadPost
 func engineReadPost(ctx *C.struct__engine_context, buffer unsafe.Pointer, length C.uint) C.int {
-	context := engine.contexts[ctx]
-	if (context == nil || context.Request == nil) {
+	context := engine.context(ctx)
+	if context == nil || context.body == nil {
 		return 0
 	}
-	bufferAsGoSlice := (*[1<<30]byte)(buffer)[:int(length):int(length)]
-	readBytesCount, err := context.Request.Body.Read(bufferAsGoSlice)
-	if (err != nil && err != io.EOF) {
+
+	n, err := context.body.Read(unsafe.Slice((*byte)(buffer), int(length)))
+	if err == ErrBodyTooLarge {
+		// Scripts see the body end early rather than silently truncated, and
+		// the response is rejected.
+		if context.body.reject {
+			context.rejectBody()
+		}
+
 		return -1
 	}
-	return C.int(readBytesCount)
+
+	if n == 0 && err != nil && err != io.EOF {
+		return -1
+	}
+
+	return C.int(n)
+}
+
+//export engineFlush
+func engineFlush(ctx *C.struct__engine_context) {
+	context := engine.context(ctx)
+	if context == nil || context.discard {
+		return
+	}
+
+	context.flush()
+}
+
+//export engineFinishRequest
+func engineFinishRequest(ctx *C.struct__engine_context) {
+	if context := engine.context(ctx); context != nil {
+		context.finish()
+	}
 }
 
 //export engineSendHeaders
-func engineSendHeaders(ctx *C.struct__engine_context, httpResponseCode_ C.int) {
-	context := engine.contexts[ctx]
-	if (context == nil || context.ResponseWriter == nil) {
+func engineSendHeaders(ctx *C.struct__engine_context, code C.int, statusLine *C.char) {
+	context := engine.context(ctx)
+	if context == nil {
 		return
 	}
-	httpResponseCode := int(httpResponseCode_)
-	if httpResponseCode == 0 {
-		httpResponseCode = 200
+
+	status, reason := int(code), ""
+	if statusLine != nil {
+		status, reason = parseStatusLine(C.GoString(statusLine), status)
+	}
+
+	if status == 0 {
+		status = http.StatusOK
 	}
-	context.ResponseWriter.WriteHeader(httpResponseCode)
+
+	context.writeHeader(status, reason)
 }
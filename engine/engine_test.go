@@ -7,6 +7,7 @@ package engine
 import (
 	"io/ioutil"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -56,6 +57,43 @@ func TestEngineNewContext(t *testing.T) {
 	}
 }
 
+func TestEngineConcurrentContexts(t *testing.T) {
+	Initialize()
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			c := &Context{}
+			if err := RequestStartup(c); err != nil {
+				t.Errorf("RequestStartup(): %s", err)
+				return
+			}
+			defer RequestShutdown(c)
+
+			if err := c.Bind("i", i); err != nil {
+				t.Errorf("Context.Bind(): %s", err)
+				return
+			}
+
+			val, err := c.Eval("return $i * 2;")
+			if err != nil {
+				t.Errorf("Context.Eval(): %s", err)
+				return
+			}
+			defer DestroyValue(val)
+
+			if ToInt(val) != int64(i*2) {
+				t.Errorf("Context.Eval(): expected %d, actual %d", i*2, ToInt(val))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
 func TestEngineDefine(t *testing.T) {
 	Initialize()
 	ctor := func(args []interface{}) interface{} {
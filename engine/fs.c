@@ -0,0 +1,185 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <errno.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <main/php.h>
+#include <main/SAPI.h>
+
+#include "context.h"
+#include "fs.h"
+#include "_cgo_export.h"
+
+static int (*fs_orig_stream_open)(const char *filename, zend_file_handle *handle);
+static zend_op_array *(*fs_orig_compile_file)(zend_file_handle *handle, int type);
+
+// Returns 1 if the file passed is to be resolved against the file system set
+// for the active context, if any. URLs are always handled by stream wrappers.
+static int fs_active(const char *filename) {
+	engine_context *context = SG(server_context);
+	if (context == NULL || !context->uses_fs) {
+		return 0;
+	}
+
+	return strstr(filename, "://") == NULL;
+}
+
+// Returns the name of the currently executing file, against which relative
+// paths are resolved.
+static char *fs_executing_filename() {
+	if (!zend_is_executing()) {
+		return NULL;
+	}
+
+	return (char *) zend_get_executed_filename();
+}
+
+static zend_string *fs_resolve_path(const char *filename) {
+	char *path = engineResolveFS(SG(server_context), (char *) filename, fs_executing_filename());
+	if (path == NULL) {
+		return NULL;
+	}
+
+	zend_string *resolved = zend_string_init(path, strlen(path), 0);
+	free(path);
+
+	return resolved;
+}
+
+static size_t fs_file_read(fs_file *file, char *buf, size_t len) {
+	size_t remaining = file->length - file->pos;
+	if (len > remaining) {
+		len = remaining;
+	}
+
+	memcpy(buf, file->data + file->pos, len);
+	file->pos += len;
+
+	return len;
+}
+
+static size_t fs_file_size(void *handle) {
+	return ((fs_file *) handle)->length;
+}
+
+static void fs_file_close(void *handle) {
+	fs_file *file = (fs_file *) handle;
+
+	free(file->data);
+	free(file);
+}
+
+static int fs_stream_open(const char *filename, zend_file_handle *handle) {
+	if (!fs_active(filename)) {
+		return fs_orig_stream_open(filename, handle);
+	}
+
+	char *path = NULL, *data = NULL;
+	size_t length = 0;
+
+	if (!engineOpenFS(SG(server_context), (char *) filename, fs_executing_filename(), &path, &data, &length)) {
+		return FAILURE;
+	}
+
+	int ret = fs_file_handle_init(handle, filename, path, data, length);
+	free(path);
+
+	return ret;
+}
+
+// Compiles scripts for contexts using a file system with PHP's own compiler,
+// bypassing OPcache, which opens files by name via its own saved hooks, and
+// caches compiled scripts by path, mixing up files with those on disk.
+static zend_op_array *fs_compile_file(zend_file_handle *handle, int type) {
+	engine_context *context = SG(server_context);
+	if (context != NULL && context->uses_fs) {
+		return compile_file(handle, type);
+	}
+
+	return fs_orig_compile_file(handle, type);
+}
+
+// Executes the script for the absolute path passed from the context's file
+// system. The script is opened as a stream, rather than by name, and is never
+// looked up on disk.
+void fs_exec(engine_context *context, char *filename) {
+	char *path = NULL, *data = NULL;
+	size_t length = 0;
+	int ret;
+
+	if (!engineOpenFS(context, filename, NULL, &path, &data, &length)) {
+		errno = 1;
+		return;
+	}
+
+	zend_file_handle script;
+	ret = fs_file_handle_init(&script, filename, path, data, length);
+	free(path);
+
+	if (ret == FAILURE) {
+		errno = 1;
+		return;
+	}
+
+	zend_first_try {
+		ret = php_execute_script(&script);
+	} zend_catch {
+		errno = 1;
+		return;
+	} zend_end_try();
+
+	if (ret == FAILURE) {
+		errno = 1;
+		return;
+	}
+
+	errno = 0;
+}
+
+// Initializes the file handle passed for reading the contents passed, which are
+// freed once the handle is destroyed.
+int fs_file_handle_init(zend_file_handle *handle, const char *filename, const char *opened_path, char *data, size_t length) {
+	fs_file *file = malloc(sizeof(fs_file));
+	if (file == NULL) {
+		free(data);
+		return FAILURE;
+	}
+
+	file->data = data;
+	file->length = length;
+	file->pos = 0;
+
+	memset(handle, 0, sizeof(zend_file_handle));
+
+	handle->type = ZEND_HANDLE_STREAM;
+	handle->filename = filename;
+	handle->free_filename = 0;
+	handle->opened_path = zend_string_init(opened_path, strlen(opened_path), 0);
+	handle->handle.stream.handle = file;
+	handle->handle.stream.isatty = 0;
+	handle->handle.stream.reader = _fs_file_read;
+	handle->handle.stream.fsizer = fs_file_size;
+	handle->handle.stream.closer = fs_file_close;
+
+	return SUCCESS;
+}
+
+// Installs the hooks resolving, opening and compiling scripts included by
+// scripts run via ExecFS. These take precedence over hooks installed by
+// extensions such as OPcache, which are called for other contexts only, and
+// are therefore installed once all extensions have been started.
+void fs_init() {
+	fs_orig_stream_open = zend_stream_open_function;
+	zend_stream_open_function = fs_stream_open;
+
+	fs_orig_compile_file = zend_compile_file;
+	zend_compile_file = fs_compile_file;
+
+	_fs_orig_resolve_path = zend_resolve_path;
+	zend_resolve_path = _fs_resolve_path;
+}
+
+#include "_fs.c"
@@ -0,0 +1,129 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "context.h"
+// #include "fs.h"
+import "C"
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"unsafe"
+)
+
+// ExecFS executes the named PHP script from the file system passed, e.g. an
+// embed.FS, in the current execution context, and returns an error, if any.
+//
+// Scripts are run under absolute paths rooted at the file system, e.g. as
+// '/app/index.php' for the name 'app/index.php', as reported by __FILE__.
+// Files included by scripts are resolved against the same file system for the
+// remainder of the request, or until Exec is called: absolute paths against its
+// root, and relative paths against the directory of the including script, and
+// failing that, against the root. Other file functions, e.g. fopen(), are not
+// affected, see RegisterStreamWrapper and NewFSStreamWrapper.
+//
+// Scripts run from file systems are not cached by OPcache, and are compiled
+// anew for each request.
+func (c *Context) ExecFS(fsys fs.FS, name string) error {
+	filename := "/" + fsName(name)
+
+	f := C.CString(filename)
+	defer C.free(unsafe.Pointer(f))
+
+	var err error
+	c.thread.call(func() {
+		c.fs = fsys
+		c.context.uses_fs = 1
+		_, err = C.fs_exec(c.context, f)
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error executing script '%s' in context", filename)
+	}
+	if c.body != nil && c.body.exceeded {
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+// resolve returns the absolute path for the file included, relative to the
+// root of the context's file system, or false if no such file exists.
+func (c *Context) resolve(filename, executing string) (string, bool) {
+	if c.fs == nil {
+		return "", false
+	}
+
+	candidates := []string{filename}
+	if !path.IsAbs(filename) {
+		candidates = []string{"/" + filename}
+		if path.IsAbs(executing) {
+			candidates = append([]string{path.Join(path.Dir(executing), filename)}, candidates...)
+		}
+	}
+
+	for _, candidate := range candidates {
+		candidate = path.Clean(candidate)
+		if info, err := fs.Stat(c.fs, fsName(candidate)); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+//export engineResolveFS
+func engineResolveFS(ctx *C.struct__engine_context, filename *C.char, executing *C.char) *C.char {
+	context := engine.context(ctx)
+	if context == nil {
+		return nil
+	}
+
+	var exec string
+	if executing != nil {
+		exec = C.GoString(executing)
+	}
+
+	resolved, ok := context.resolve(C.GoString(filename), exec)
+	if !ok {
+		return nil
+	}
+
+	return C.CString(resolved)
+}
+
+//export engineOpenFS
+func engineOpenFS(ctx *C.struct__engine_context, filename *C.char, executing *C.char, resolvedPath **C.char, data **C.char, length *C.size_t) C.int {
+	context := engine.context(ctx)
+	if context == nil {
+		return 0
+	}
+
+	var exec string
+	if executing != nil {
+		exec = C.GoString(executing)
+	}
+
+	resolved, ok := context.resolve(C.GoString(filename), exec)
+	if !ok {
+		return 0
+	}
+
+	contents, err := fs.ReadFile(context.fs, fsName(resolved))
+	if err != nil {
+		return 0
+	}
+
+	// The path and contents are copied into memory owned by the caller, which
+	// frees them once the script has been compiled.
+	*resolvedPath = C.CString(resolved)
+	*data = (*C.char)(C.CBytes(contents))
+	*length = C.size_t(len(contents))
+
+	return 1
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var execFSFiles = fstest.MapFS{
+	"app/index.php":   {Data: []byte("<?php require 'lib/a.php'; require_once __DIR__ . '/lib/b.php'; echo __FILE__, ':', a(), b();")},
+	"app/lib/a.php":   {Data: []byte("<?php require_once 'b.php'; function a() { return 'a'; }")},
+	"app/lib/b.php":   {Data: []byte("<?php function b() { return 'b'; }")},
+	"app/missing.php": {Data: []byte("<?php echo (@include 'nothing.php') === false ? 'missing' : 'found';")},
+}
+
+var execFSTests = []struct {
+	name     string
+	expected string
+}{
+	{"app/index.php", "/app/index.php:ab"},
+	{"/app/missing.php", "missing"},
+}
+
+func TestContextExecFS(t *testing.T) {
+	Initialize()
+
+	for _, tt := range execFSTests {
+		var w bytes.Buffer
+
+		c := &Context{Output: &w}
+		if err := RequestStartup(c); err != nil {
+			t.Fatalf("RequestStartup(): %s", err)
+		}
+
+		if err := c.ExecFS(execFSFiles, tt.name); err != nil {
+			t.Errorf("Context.ExecFS('%s'): %s", tt.name, err)
+		}
+
+		RequestShutdown(c)
+
+		if w.String() != tt.expected {
+			t.Errorf("Context.ExecFS('%s'): expected '%s', actual '%s'", tt.name, tt.expected, w.String())
+		}
+	}
+
+	c := &Context{}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	if err := c.ExecFS(execFSFiles, "app/none.php"); err == nil {
+		t.Errorf("Context.ExecFS(): expected error for missing script")
+	}
+}
+
+func TestContextExecFSOpcache(t *testing.T) {
+	Initialize()
+
+	root, err := ioutil.TempDir("", "gophp-fs")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	// The same paths exist on disk and in the file system, so that scripts
+	// cached by OPcache for either would be returned for the other.
+	files := map[string]string{
+		"index.php": "<?php echo 'disk:'; include 'inc.php';",
+		"inc.php":   "<?php echo 'disk';",
+	}
+
+	fsys := fstest.MapFS{}
+	for name, contents := range files {
+		file := filepath.Join(root, name)
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatalf("Could not create file '%s' for testing: %s", name, err)
+		}
+
+		fsys[fsName(file)] = &fstest.MapFile{Data: []byte(strings.Replace(contents, "disk", "fs", -1))}
+	}
+
+	index := filepath.Join(root, "index.php")
+	run := func(exec func(c *Context) error) string {
+		var w bytes.Buffer
+
+		c := &Context{Output: &w}
+		if err := RequestStartup(c); err != nil {
+			t.Fatalf("RequestStartup(): %s", err)
+		}
+		defer RequestShutdown(c)
+
+		enabled, _ := c.Eval("return function_exists('opcache_get_status') && opcache_get_status(false) !== false;")
+		defer DestroyValue(enabled)
+		if !ToBool(enabled) {
+			t.Skip("OPcache is not active")
+		}
+
+		if err := exec(c); err != nil {
+			t.Errorf("Context.ExecFS(): %s", err)
+		}
+
+		return w.String()
+	}
+
+	for i := 0; i < 2; i++ {
+		if out := run(func(c *Context) error { return c.Exec(index) }); out != "disk:disk" {
+			t.Errorf("Context.Exec('%s'): expected 'disk:disk', actual '%s'", index, out)
+		}
+
+		if out := run(func(c *Context) error { return c.ExecFS(fsys, index) }); out != "fs:fs" {
+			t.Errorf("Context.ExecFS('%s'): expected 'fs:fs', actual '%s'", index, out)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HandlerConfig represents the configuration for a handler serving PHP scripts
+// and static files from a document root.
+type HandlerConfig struct {
+	// The directory scripts and static files are served from. Files resolving to
+	// paths outside the document root through symbolic links are not served.
+	DocumentRoot string
+
+	// The script executed for requests to directories, typically 'index.php'.
+	Index string
+
+	// The script executed for requests not matching any file under the document
+	// root, relative to the document root, e.g. 'index.php'. Requests that do
+	// not match any file result in a 404 response if left unset.
+	FrontController string
+
+	// The writer used for debug output produced by scripts, as well as errors
+	// encountered while executing them.
+	Log io.Writer
+
+	// The size of the buffer for output produced by scripts, see
+	// Context.OutputBufferSize.
+	OutputBufferSize int
+
+	// The maximum time scripts may continue running after completing the
+	// response, see Context.MaxBackgroundTime.
+	MaxBackgroundTime time.Duration
+
+	// The maximum size of request bodies, in bytes, see Context.MaxBodyBytes.
+	// Requests with larger bodies result in a 413 response.
+	MaxBodyBytes int64
+
+	// The configuration for parsing multipart form data in Go, see UploadConfig.
+	// Uploads are handled by PHP if left unset.
+	Uploads *UploadConfig
+
+	// Path segments starting with '.' allowed in request paths, e.g.
+	// '.well-known'. Requests for any other such paths (e.g. '/.git/config' or
+	// '/.env') result in a 403 response.
+	AllowedHiddenSegments []string
+
+	// Proxies trusted to report the original client address, scheme and host,
+	// see Context.TrustedProxies.
+	TrustedProxies []*net.IPNet
+
+	// Determines whether request headers with underscores in their names are
+	// registered in $_SERVER, see UnderscoreHeaderPolicy.
+	UnderscoreHeaders UnderscoreHeaderPolicy
+
+	// Called with the variables registered in $_SERVER for each request, see
+	// Context.OnServerValues.
+	OnServerValues func(values map[string]interface{})
+
+	// Called with the response status and headers before these are sent, see
+	// Context.OnSendHeaders.
+	OnSendHeaders func(status int, header http.Header) (int, error)
+}
+
+// NewHandler returns an http.Handler serving requests from the configured
+// document root. Requests for PHP scripts, as well as requests with trailing
+// path information (e.g. '/index.php/path/info'), are executed in a new
+// context, while requests for other files are served directly.
+func NewHandler(config HandlerConfig) http.Handler {
+	root, err := filepath.Abs(config.DocumentRoot)
+	if err == nil {
+		config.DocumentRoot = root
+	}
+
+	// Files are checked against the document root with any symbolic links in
+	// its own path resolved.
+	resolved, err := filepath.EvalSymlinks(config.DocumentRoot)
+	if err != nil {
+		resolved = config.DocumentRoot
+	}
+
+	return &handler{config: config, root: resolved}
+}
+
+type handler struct {
+	config HandlerConfig
+	root   string
+}
+
+// ServeHTTP resolves the file requested and either executes it, if it is a PHP
+// script, or serves it directly.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+
+	// Deny any attempts at escaping the document root, or at accessing hidden
+	// files and directories.
+	for _, segment := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(segment, ".") && !h.allowHidden(segment) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	file, pathInfo, found := h.resolve(urlPath)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Requests for directories without a trailing slash are redirected, as is
+	// done by http.FileServer, so that relative links resolve correctly.
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		target := path.Base(urlPath) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	if filepath.Ext(file) != ".php" {
+		http.ServeFile(w, r, file)
+		return
+	}
+
+	ctx := &Context{
+		Log:               h.config.Log,
+		Request:           r,
+		ResponseWriter:    w,
+		DocumentRoot:      h.config.DocumentRoot,
+		ScriptFileName:    file,
+		PathInfo:          pathInfo,
+		OnServerValues:    h.config.OnServerValues,
+		OnSendHeaders:     h.config.OnSendHeaders,
+		UnderscoreHeaders: h.config.UnderscoreHeaders,
+		TrustedProxies:    h.config.TrustedProxies,
+		MaxBodyBytes:      h.config.MaxBodyBytes,
+		OutputBufferSize:  h.config.OutputBufferSize,
+		Uploads:           h.config.Uploads,
+		MaxBackgroundTime: h.config.MaxBackgroundTime,
+	}
+
+	err := RequestStartup(ctx)
+	if err == ErrBodyTooLarge {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		h.log("Failed to start request for '%s': %s", file, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Scripts completing the response via fastcgi_finish_request() continue
+	// running in the background, while the response is returned to the client.
+	done := make(chan error, 1)
+	go func() {
+		err := ctx.Exec(file)
+		RequestShutdown(ctx)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Finished():
+		go func() {
+			if err := <-done; err != nil {
+				h.log("%s", err)
+			}
+		}()
+		return
+	}
+
+	// Requests with bodies exceeding the maximum size have already been
+	// responded to.
+	if err != nil && err != ErrBodyTooLarge {
+		h.log("%s", err)
+	}
+}
+
+// resolve returns the file under the document root corresponding to the URL
+// path passed, along with any trailing path information for PHP scripts. The
+// directory itself is returned for directories requested without a trailing
+// slash.
+func (h *handler) resolve(urlPath string) (file string, pathInfo string, found bool) {
+	segments := strings.Split(strings.TrimLeft(urlPath, "/"), "/")
+	current := h.config.DocumentRoot
+
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+
+		current = filepath.Join(current, segment)
+		info, err := os.Stat(current)
+		if err != nil {
+			return h.frontController(urlPath)
+		}
+
+		if info.IsDir() {
+			continue
+		}
+
+		// Path information is only allowed for scripts, as is done by most web
+		// servers by default.
+		if i < len(segments)-1 {
+			if filepath.Ext(current) != ".php" {
+				return h.frontController(urlPath)
+			}
+
+			return current, "/" + strings.Join(segments[i+1:], "/"), h.contains(current)
+		}
+
+		return current, "", h.contains(current)
+	}
+
+	if current != h.config.DocumentRoot && !strings.HasSuffix(urlPath, "/") {
+		return current, "", h.contains(current)
+	}
+
+	if h.config.Index != "" {
+		index := filepath.Join(current, h.config.Index)
+		if info, err := os.Stat(index); err == nil && !info.IsDir() {
+			return index, "", h.contains(index)
+		}
+	}
+
+	return h.frontController(urlPath)
+}
+
+// frontController returns the configured front controller script, if any,
+// with the full URL path as path information.
+func (h *handler) frontController(urlPath string) (string, string, bool) {
+	if h.config.FrontController == "" {
+		return "", "", false
+	}
+
+	return filepath.Join(h.config.DocumentRoot, h.config.FrontController), urlPath, true
+}
+
+// contains returns true if the file passed, with any symbolic links resolved,
+// is located under the document root.
+func (h *handler) contains(file string) bool {
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(h.root, resolved)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// allowHidden returns true if the path segment passed, starting with '.', is
+// explicitly allowed in request paths. Parent directory segments never are.
+func (h *handler) allowHidden(segment string) bool {
+	if segment == ".." {
+		return false
+	}
+
+	for _, allowed := range h.config.AllowedHiddenSegments {
+		if segment == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *handler) log(format string, args ...interface{}) {
+	if h.config.Log != nil {
+		fmt.Fprintf(h.config.Log, format+"\n", args...)
+	}
+}
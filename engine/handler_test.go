@@ -0,0 +1,238 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var handlerFiles = map[string]string{
+	"index.php":     `<?php echo 'index:'.$_SERVER['SCRIPT_NAME'].':'.(isset($_SERVER['PATH_INFO']) ? $_SERVER['PATH_INFO'] : '');`,
+	"app.php":       `<?php echo 'app:'.$_SERVER['PATH_INFO'];`,
+	"static.txt":    `static`,
+	"sub/index.php": `<?php echo 'sub:'.$_SERVER['SCRIPT_NAME'];`,
+	"finish.php":    `<?php echo 'finished'; fastcgi_finish_request(); usleep(100000); echo 'discarded';`,
+	".env":          `SECRET=secret`,
+	".well-known/a": `well-known`,
+}
+
+var handlerTests = []struct {
+	config   HandlerConfig
+	path     string
+	code     int
+	expected string
+}{
+	{HandlerConfig{Index: "index.php"}, "/", 200, "index:/index.php:"},
+	{HandlerConfig{Index: "index.php"}, "/index.php", 200, "index:/index.php:"},
+	{HandlerConfig{Index: "index.php"}, "/index.php/path/info", 200, "index:/index.php:/path/info"},
+	{HandlerConfig{Index: "index.php"}, "/sub/", 200, "sub:/sub/index.php"},
+	{HandlerConfig{Index: "index.php"}, "/static.txt", 200, "static"},
+	{HandlerConfig{Index: "index.php"}, "/static.txt/path", 404, "404 page not found\n"},
+	{HandlerConfig{Index: "index.php"}, "/missing", 404, "404 page not found\n"},
+	{HandlerConfig{Index: "index.php"}, "/sub/../../etc/passwd", 403, "Forbidden\n"},
+	{HandlerConfig{Index: "index.php"}, "/sub", 301, ""},
+	{HandlerConfig{Index: "index.php"}, "/.env", 403, "Forbidden\n"},
+	{HandlerConfig{Index: "index.php"}, "/.well-known/a", 403, "Forbidden\n"},
+	{HandlerConfig{AllowedHiddenSegments: []string{".well-known"}}, "/.well-known/a", 200, "well-known"},
+	{HandlerConfig{AllowedHiddenSegments: []string{".."}}, "/sub/../../etc/passwd", 403, "Forbidden\n"},
+	{HandlerConfig{Index: "index.php"}, "/outside.txt", 404, "404 page not found\n"},
+	{HandlerConfig{FrontController: "app.php"}, "/missing/route", 200, "app:/missing/route"},
+	{HandlerConfig{FrontController: "app.php"}, "/", 200, "app:/"},
+	{HandlerConfig{}, "/finish.php", 200, "finished"},
+}
+
+func TestHandler(t *testing.T) {
+	Initialize()
+	root, err := ioutil.TempDir("", "gophp-handler")
+	if err != nil {
+		t.Fatalf("Could not create temporary document root: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	for name, contents := range handlerFiles {
+		file := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatalf("Could not create file '%s' for testing: %s", name, err)
+		}
+	}
+
+	outside, err := ioutil.TempFile("", "gophp-outside")
+	if err != nil {
+		t.Fatalf("Could not create file outside document root: %s", err)
+	}
+	outside.Close()
+	defer os.Remove(outside.Name())
+
+	if err := os.Symlink(outside.Name(), filepath.Join(root, "outside.txt")); err != nil {
+		t.Fatalf("Could not create symbolic link for testing: %s", err)
+	}
+
+	for _, tt := range handlerTests {
+		tt.config.DocumentRoot = root
+		h := NewHandler(tt.config)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = tt.path
+		h.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.code {
+			t.Errorf("Handler.ServeHTTP('%s'): expected status %d, actual %d", tt.path, tt.code, recorder.Code)
+		}
+
+		if tt.code == http.StatusMovedPermanently && recorder.Header().Get("Location") != filepath.Base(tt.path)+"/" {
+			t.Errorf("Handler.ServeHTTP('%s'): expected redirect to '%s/', actual '%s'", tt.path, filepath.Base(tt.path), recorder.Header().Get("Location"))
+		}
+
+		if recorder.Body.String() != tt.expected {
+			t.Errorf("Handler.ServeHTTP('%s'): expected '%s', actual '%s'", tt.path, tt.expected, recorder.Body.String())
+		}
+	}
+}
+
+// detachedRecorder records responses, and flags any use of the response writer
+// or request body once the handler has returned.
+type detachedRecorder struct {
+	*httptest.ResponseRecorder
+	returned int32
+	misused  int32
+}
+
+func (r *detachedRecorder) check() {
+	if atomic.LoadInt32(&r.returned) == 1 {
+		atomic.StoreInt32(&r.misused, 1)
+	}
+}
+
+func (r *detachedRecorder) Header() http.Header {
+	r.check()
+	return r.ResponseRecorder.Header()
+}
+
+func (r *detachedRecorder) Write(p []byte) (int, error) {
+	r.check()
+	return r.ResponseRecorder.Write(p)
+}
+
+func (r *detachedRecorder) WriteHeader(code int) {
+	r.check()
+	r.ResponseRecorder.WriteHeader(code)
+}
+
+func (r *detachedRecorder) Flush() {
+	r.check()
+	r.ResponseRecorder.Flush()
+}
+
+func (r *detachedRecorder) Read(p []byte) (int, error) {
+	r.check()
+	return 0, io.EOF
+}
+
+func (r *detachedRecorder) Close() error {
+	return nil
+}
+
+func TestHandlerDetachesFinishedRequests(t *testing.T) {
+	Initialize()
+	root, err := ioutil.TempDir("", "gophp-handler")
+	if err != nil {
+		t.Fatalf("Could not create temporary document root: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	done := filepath.Join(root, "done")
+	script := `<?php
+	echo 'finished';
+	fastcgi_finish_request();
+	usleep(100000);
+	header('X-After: 1');
+	echo 'discarded';
+	flush();
+	file_get_contents('php://input');
+	touch('` + done + `');`
+
+	if err := ioutil.WriteFile(filepath.Join(root, "index.php"), []byte(script), 0644); err != nil {
+		t.Fatalf("Could not create script for testing: %s", err)
+	}
+
+	recorder := &detachedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	req := httptest.NewRequest(http.MethodPost, "/index.php", nil)
+	req.Body = recorder
+
+	NewHandler(HandlerConfig{DocumentRoot: root}).ServeHTTP(recorder, req)
+	atomic.StoreInt32(&recorder.returned, 1)
+
+	if recorder.Body.String() != "finished" {
+		t.Errorf("Handler.ServeHTTP(): expected 'finished', actual '%s'", recorder.Body.String())
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(done); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(done); err != nil {
+		t.Fatalf("Handler.ServeHTTP(): script did not complete in the background")
+	}
+
+	if atomic.LoadInt32(&recorder.misused) == 1 {
+		t.Errorf("Handler.ServeHTTP(): response writer or request body used after returning")
+	}
+
+	if recorder.Body.String() != "finished" || recorder.Header().Get("X-After") != "" {
+		t.Errorf("Handler.ServeHTTP(): output written after returning")
+	}
+}
+
+func TestHandlerBodyTooLarge(t *testing.T) {
+	Initialize()
+	root, err := ioutil.TempDir("", "gophp-handler")
+	if err != nil {
+		t.Fatalf("Could not create temporary document root: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	script := `<?php echo strlen(file_get_contents('php://input'));`
+	if err := ioutil.WriteFile(filepath.Join(root, "index.php"), []byte(script), 0644); err != nil {
+		t.Fatalf("Could not create script for testing: %s", err)
+	}
+
+	h := NewHandler(HandlerConfig{DocumentRoot: root, MaxBodyBytes: 8})
+
+	// Bodies without a known length are only found to be too large once read
+	// by the script.
+	recorder := httptest.NewRecorder()
+	body := struct{ io.Reader }{strings.NewReader("0123456789")}
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/index.php", body))
+
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Handler.ServeHTTP(): expected status 413, actual %d", recorder.Code)
+	}
+
+	if recorder.Body.String() != "Request Entity Too Large\n" {
+		t.Errorf("Handler.ServeHTTP(): expected script output discarded, actual '%s'", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	body = struct{ io.Reader }{strings.NewReader("01234567")}
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/index.php", body))
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "8" {
+		t.Errorf("Handler.ServeHTTP(): expected '8', actual %d '%s'", recorder.Code, recorder.Body.String())
+	}
+}
@@ -10,6 +10,13 @@ import (
 	"mime/multipart"
 	"time"
 	"os"
+	"io/ioutil"
+	"strings"
+	"testing/iotest"
+	"context"
+	"net"
+	"io"
+	"path/filepath"
 )
 
 func Test_SERVER_REQUEST_URI(t *testing.T) {
@@ -280,3 +287,440 @@ func Test_environment_variables(t *testing.T) {
 		}
 	})
 }
+
+func Test_SERVER_REMOTE_ADDR_ipv6(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.RemoteAddr = "[2001:db8::1]:5555"
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_SERVER['REMOTE_ADDR'].' '.$_SERVER['REMOTE_PORT'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "2001:db8::1 5555" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_SERVER_NAME_ipv6(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Host = "[2001:db8::1]:8080"
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_SERVER['SERVER_NAME'].' '.$_SERVER['SERVER_PORT'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "2001:db8::1 8080" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_SERVER_PORT_default(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/hello", nil)
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_SERVER['SERVER_PORT'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "443" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_SERVER_PROTOCOL(t *testing.T) {
+	evalAssert(&Context{
+		Request: httptest.NewRequest(http.MethodGet, "/hello", nil),
+	}, "return $_SERVER['SERVER_PROTOCOL'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "HTTP/1.1" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_GATEWAY_INTERFACE(t *testing.T) {
+	evalAssert(&Context{
+		Request: httptest.NewRequest(http.MethodGet, "/hello", nil),
+	}, "return $_SERVER['GATEWAY_INTERFACE'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "CGI/1.1" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_HTTPS(t *testing.T) {
+	evalAssert(&Context{
+		Request: httptest.NewRequest(http.MethodGet, "https://example.com/hello", nil),
+	}, "return $_SERVER['HTTPS'].' '.$_SERVER['REQUEST_SCHEME'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "on https" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+	evalAssert(&Context{
+		Request: httptest.NewRequest(http.MethodGet, "/hello", nil),
+	}, "return isset($_SERVER['HTTPS']) ? 'set' : $_SERVER['REQUEST_SCHEME'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "http" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_REQUEST_TIME(t *testing.T) {
+	before := time.Now().Unix()
+	evalAssert(&Context{
+		Request: httptest.NewRequest(http.MethodGet, "/hello", nil),
+	}, "return [$_SERVER['REQUEST_TIME'], $_SERVER['REQUEST_TIME_FLOAT']];", func(val evalAssertionArg) {
+		times := ToSlice(val.val)
+		if times[0].(int64) < before || times[1].(float64) < float64(before) {
+			t.Fatal(times)
+		}
+	})
+}
+
+func Test_SERVER_PATH_INFO(t *testing.T) {
+	evalAssert(&Context{
+		Request: httptest.NewRequest(http.MethodGet, "/index.php/path/info", nil),
+		DocumentRoot: "/docroot",
+		ScriptFileName: "/docroot/index.php",
+		PathInfo: "/path/info",
+	}, "return $_SERVER['PATH_INFO'].' '.$_SERVER['PATH_TRANSLATED'].' '.$_SERVER['PHP_SELF'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "/path/info /docroot/path/info /index.php/path/info" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_CONTENT_TYPE(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/hello", bytes.NewBufferString("a=b"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", "3")
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_SERVER['CONTENT_TYPE'].' '.$_SERVER['CONTENT_LENGTH'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "application/x-www-form-urlencoded 3" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_OnServerValues(t *testing.T) {
+	evalAssert(&Context{
+		Request: httptest.NewRequest(http.MethodGet, "/hello", nil),
+		OnServerValues: func(values map[string]interface{}) {
+			values["APP_ENV"] = "testing"
+			values["REQUEST_URI"] = "/rewritten"
+		},
+	}, "return $_SERVER['APP_ENV'].' '.$_SERVER['REQUEST_URI'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "testing /rewritten" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_repeated_headers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Cookie", "a=1")
+	req.Header.Add("Cookie", "b=2")
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_SERVER['HTTP_ACCEPT'].'|'.$_SERVER['HTTP_COOKIE'].'|'.$_COOKIE['b'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "text/html, application/json|a=1; b=2|2" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_underscore_headers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header["X-Forwarded-For"] = []string{"10.0.0.1"}
+	req.Header["X_Forwarded_For"] = []string{"6.6.6.6"}
+	req.Header["X_Custom"] = []string{"custom"}
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_SERVER['HTTP_X_FORWARDED_FOR'].'|'.(isset($_SERVER['HTTP_X_CUSTOM']) ? 'set' : 'unset');", func(val evalAssertionArg) {
+		if ToString(val.val) != "10.0.0.1|unset" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+	evalAssert(&Context{
+		Request: req,
+		UnderscoreHeaders: AllowUnderscoreHeaders,
+	}, "return $_SERVER['HTTP_X_FORWARDED_FOR'].'|'.$_SERVER['HTTP_X_CUSTOM'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "10.0.0.1|custom" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_getallheaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Host = "example.com"
+	req.Header.Add("X-Testing", "a")
+	req.Header.Add("X-Testing", "b")
+	evalAssert(&Context{
+		Request: req,
+	}, "$h = getallheaders(); return $h['Host'].'|'.$h['X-Testing'].'|'.apache_request_headers()['X-Testing'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "example.com|a, b|a, b" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_SERVER_trusted_proxies(t *testing.T) {
+	trusted, err := ParseTrustedProxies("192.0.2.0/24", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := "return $_SERVER['REMOTE_ADDR'].'|'.(isset($_SERVER['HTTPS']) ? 'on' : 'off').'|'.$_SERVER['HTTP_HOST'].'|'.$_SERVER['SERVER_PORT'];"
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Add("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.Header.Add("X-Forwarded-Proto", "https")
+	req.Header.Add("X-Forwarded-Host", "example.com")
+	evalAssert(&Context{
+		Request:        req,
+		TrustedProxies: trusted,
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "203.0.113.7|on|example.com|443" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	req = httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Add("Forwarded", `for="[2001:db8::1]:4711";proto=https;host=example.com:8443, for=10.0.0.1`)
+	evalAssert(&Context{
+		Request:        req,
+		TrustedProxies: trusted,
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "2001:db8::1|on|example.com:8443|8443" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	// Values sent by the client are not taken over those appended by proxies.
+	req = httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Add("X-Forwarded-For", "203.0.113.7")
+	req.Header.Add("X-Forwarded-Proto", "https, http")
+	req.Header.Add("X-Forwarded-Host", "evil.example, example.com")
+	req.Header.Add("X-Forwarded-Port", "8443, 80")
+	evalAssert(&Context{
+		Request:        req,
+		TrustedProxies: trusted,
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "203.0.113.7|off|example.com|80" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	// The local port is kept for trusted peers sending no forwarding headers.
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/hello", nil)
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}))
+	evalAssert(&Context{
+		Request:        req,
+		TrustedProxies: trusted,
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "192.0.2.1|off|localhost|8080" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	// Headers sent by untrusted peers are ignored.
+	req = httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.Header.Add("X-Forwarded-For", "203.0.113.7")
+	req.Header.Add("X-Forwarded-Proto", "https")
+	evalAssert(&Context{
+		Request:        req,
+		TrustedProxies: trusted,
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "198.51.100.1|off|example.com|80" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_POST_chunked(t *testing.T) {
+	// Bodies read in small chunks and without a known length are read in full.
+	req := httptest.NewRequest(http.MethodPost, "/hello", iotest.OneByteReader(strings.NewReader("form_arg=form_value")))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_POST['form_arg'].'|'.(isset($_SERVER['CONTENT_LENGTH']) ? 'set' : 'unset');", func(val evalAssertionArg) {
+		if ToString(val.val) != "form_value|unset" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_POST_max_body_bytes(t *testing.T) {
+	Initialize()
+
+	req := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader("form_arg=form_value"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if err := RequestStartup(&Context{Request: req, MaxBodyBytes: 8}); err != ErrBodyTooLarge {
+		t.Fatalf("RequestStartup(): expected error '%s', actual '%v'", ErrBodyTooLarge, err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/hello", iotest.OneByteReader(strings.NewReader("form_arg=form_value")))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if err := RequestStartup(&Context{Request: req, MaxBodyBytes: 8}); err != ErrBodyTooLarge {
+		t.Fatalf("RequestStartup(): expected error '%s' for chunked body, actual '%v'", ErrBodyTooLarge, err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader("form_arg=form_value"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	evalAssert(&Context{
+		Request:      req,
+		MaxBodyBytes: 19,
+	}, "return $_POST['form_arg'];", func(val evalAssertionArg) {
+		if ToString(val.val) != "form_value" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	// Bodies of unknown length are limited while read by scripts.
+	req = httptest.NewRequest(http.MethodPost, "/hello", iotest.OneByteReader(strings.NewReader(`{"json":true}`)))
+	req.Header.Add("Content-Type", "application/json")
+	c := &Context{Request: req, MaxBodyBytes: 8}
+	if err := RequestStartup(c); err != nil {
+		t.Fatalf("RequestStartup(): %s", err)
+	}
+	defer RequestShutdown(c)
+
+	if _, err := c.Eval("return file_get_contents('php://input');"); err != ErrBodyTooLarge {
+		t.Fatalf("Eval(): expected error '%s', actual '%v'", ErrBodyTooLarge, err)
+	}
+}
+
+func Test_php_input(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(`{"json":true}`))
+	req.Header.Add("Content-Type", "application/json")
+	evalAssert(&Context{
+		Request: req,
+	}, "return file_get_contents('php://input');", func(val evalAssertionArg) {
+		if ToString(val.val) != `{"json":true}` {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	b := bytes.Buffer{}
+	w := multipart.NewWriter(&b)
+	w.WriteField("mp_arg", "mp_value")
+	w.Close()
+	body := b.String()
+	req = httptest.NewRequest(http.MethodPost, "/hello", &b)
+	req.Header.Add("Content-Type", w.FormDataContentType())
+	evalAssert(&Context{
+		Request: req,
+	}, "return $_POST['mp_arg'].'|'.file_get_contents('php://input');", func(val evalAssertionArg) {
+		if ToString(val.val) != "mp_value|"+body {
+			t.Fatal(ToString(val.val))
+		}
+	})
+}
+
+func Test_FILE_go_uploads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophp-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newRequest := func() *http.Request {
+		b := bytes.Buffer{}
+		w := multipart.NewWriter(&b)
+		w.WriteField("mp_arg", "mp_value")
+		fw, err := w.CreateFormFile("mp_file[]", "test.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("mp_file_value"))
+		w.CreateFormFile("mp_empty", "")
+		w.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/hello", &b)
+		req.Header.Add("Content-Type", w.FormDataContentType())
+		return req
+	}
+
+	script := `$f = $_FILES['mp_file'];
+		return implode('|', [$_POST['mp_arg'], $f['name'][0], $f['size'][0], $f['error'][0], $_FILES['mp_empty']['error'],
+			is_uploaded_file($f['tmp_name'][0]) ? file_get_contents($f['tmp_name'][0]) : 'not uploaded']);`
+
+	evalAssert(&Context{
+		Request: newRequest(),
+		Uploads: &UploadConfig{Dir: dir},
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "mp_value|test.txt|13|0|4|mp_file_value" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	evalAssert(&Context{
+		Request: newRequest(),
+		Uploads: &UploadConfig{Dir: dir, MaxFileBytes: 4},
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "mp_value|test.txt|0|1|4|not uploaded" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	evalAssert(&Context{
+		Request: newRequest(),
+		Uploads: &UploadConfig{Dir: dir, Quarantine: func(file *UploadedFile) error {
+			return os.ErrPermission
+		}},
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "mp_value|test.txt|0|8|4|not uploaded" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	// Files not moved by the script are removed on request shutdown.
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("Uploaded files not removed: %d files remaining", len(files))
+	}
+
+	// Local paths returned by custom storage are tracked by PHP.
+	stored := filepath.Join(dir, "stored.txt")
+	evalAssert(&Context{
+		Request: newRequest(),
+		Uploads: &UploadConfig{Storage: func(file *UploadedFile) (io.WriteCloser, string, bool, error) {
+			f, err := os.Create(stored)
+			return f, stored, true, err
+		}},
+	}, script, func(val evalAssertionArg) {
+		if ToString(val.val) != "mp_value|test.txt|13|0|4|mp_file_value" {
+			t.Fatal(ToString(val.val))
+		}
+	})
+
+	if _, err := os.Stat(stored); !os.IsNotExist(err) {
+		t.Fatalf("File kept in custom storage under local path not removed: %v", err)
+	}
+
+	// Other names are removed via the Remove hook, both for rejected files and
+	// on request shutdown.
+	for _, config := range []*UploadConfig{{}, {MaxFileBytes: 4}} {
+		var removed []string
+		config.Storage = func(file *UploadedFile) (io.WriteCloser, string, bool, error) {
+			f, err := os.Create(stored)
+			return f, "file://" + stored, false, err
+		}
+		config.Remove = func(file *UploadedFile) {
+			removed = append(removed, file.TmpName)
+			os.Remove(stored)
+		}
+
+		evalAssert(&Context{
+			Request: newRequest(),
+			Uploads: config,
+		}, script, func(val evalAssertionArg) {
+			if !strings.HasSuffix(ToString(val.val), "|not uploaded") {
+				t.Fatal(ToString(val.val))
+			}
+		})
+
+		if len(removed) != 1 || removed[0] != "file://"+stored {
+			t.Fatalf("Remove: expected call for stored file, actual %v", removed)
+		}
+	}
+}
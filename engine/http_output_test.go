@@ -1,11 +1,15 @@
 package engine
 
 import (
+	"errors"
 	"testing"
 	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"io/ioutil"
+	"os"
+	"time"
 )
 
 func Test_finish_request(t *testing.T) {
@@ -157,3 +161,224 @@ func Test_echo_will_send_response_code(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func Test_flush_to_http_response(t *testing.T) {
+	Initialize()
+	recorder := httptest.NewRecorder()
+	c := &Context{
+		ResponseWriter: recorder,
+	}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+	c.Eval("echo('hello');")
+	if recorder.Flushed {
+		t.Fatal("response flushed without calling flush()")
+	}
+	c.Eval("flush();")
+	if !recorder.Flushed || recorder.Body.String() != "hello" {
+		t.FailNow()
+	}
+}
+
+func Test_output_buffer_size(t *testing.T) {
+	Initialize()
+	buffer := &bytes.Buffer{}
+	c := &Context{
+		OutputBufferSize: 8,
+	}
+	RequestStartup(c)
+	c.Output = buffer
+	c.Eval("echo('hello');")
+	if buffer.String() != "" {
+		t.Fatalf("output not buffered: '%s'", buffer.String())
+	}
+	c.Eval("flush();")
+	if buffer.String() != "hello" {
+		t.Fatalf("output not flushed: '%s'", buffer.String())
+	}
+	c.Eval("echo(' world');")
+	RequestShutdown(c)
+	if buffer.String() != "hello world" {
+		t.Fatalf("output not flushed on shutdown: '%s'", buffer.String())
+	}
+}
+
+func Test_send_status_code_on_shutdown(t *testing.T) {
+	Initialize()
+	recorder := httptest.NewRecorder()
+	recorder.Code = 0
+	c := &Context{
+		ResponseWriter: recorder,
+	}
+	RequestStartup(c)
+	c.Eval("http_response_code(404); header('X-Testing: Hello');")
+	RequestShutdown(c)
+	if recorder.Code != 404 || recorder.Header().Get("X-Testing") != "Hello" {
+		t.FailNow()
+	}
+}
+
+// statusRecorder records all status codes and reason phrases sent, including
+// informational responses.
+type statusRecorder struct {
+	*httptest.ResponseRecorder
+	codes  []int
+	reason string
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.codes = append(r.codes, code)
+	if code >= 200 {
+		r.ResponseRecorder.WriteHeader(code)
+	}
+}
+
+func (r *statusRecorder) WriteHeaderReason(code int, reason string) {
+	r.reason = reason
+	r.WriteHeader(code)
+}
+
+var statusTests = []struct {
+	script string
+	codes  []int
+	reason string
+}{
+	{"echo('hello');", []int{200}, ""},
+	{"header('HTTP/1.1 299 Custom'); echo('hello');", []int{299}, "Custom"},
+	{"header('HTTP/1.1 299 Custom'); http_response_code(201); echo('hello');", []int{201}, ""},
+	{"header('Status: 404 Gone Away'); echo('hello');", []int{404}, "Gone Away"},
+	{"header('HTTP/1.1 103 Early Hints'); header('Link: </style.css>; rel=preload'); flush(); echo('hello');", []int{103, 200}, ""},
+}
+
+func Test_send_status_line(t *testing.T) {
+	Initialize()
+	for _, tt := range statusTests {
+		recorder := &statusRecorder{ResponseRecorder: httptest.NewRecorder()}
+		c := &Context{
+			ResponseWriter: recorder,
+		}
+		RequestStartup(c)
+		c.Eval(tt.script)
+		RequestShutdown(c)
+
+		if !reflect.DeepEqual(recorder.codes, tt.codes) || recorder.reason != tt.reason {
+			t.Errorf("Context.Eval('%s'): expected %v '%s', actual %v '%s'", tt.script, tt.codes, tt.reason, recorder.codes, recorder.reason)
+		}
+
+		if recorder.Header().Get("Status") != "" || recorder.Body.String() != "hello" {
+			t.Errorf("Context.Eval('%s'): unexpected response %#v '%s'", tt.script, recorder.Header(), recorder.Body.String())
+		}
+	}
+}
+
+func Test_on_send_headers(t *testing.T) {
+	Initialize()
+	recorder := httptest.NewRecorder()
+	c := &Context{
+		ResponseWriter: recorder,
+		OnSendHeaders: func(status int, header http.Header) (int, error) {
+			header.Set("X-Frame-Options", "DENY")
+			if header.Get("Location") != "" {
+				return http.StatusSeeOther, nil
+			}
+			return 0, nil
+		},
+	}
+	RequestStartup(c)
+	c.Eval("header('Location: /elsewhere'); echo('hello');")
+	RequestShutdown(c)
+	if recorder.Code != http.StatusSeeOther || recorder.Header().Get("X-Frame-Options") != "DENY" || recorder.Body.String() != "hello" {
+		t.Fatalf("unexpected response %d %#v '%s'", recorder.Code, recorder.Header(), recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	c = &Context{
+		ResponseWriter: recorder,
+		OnSendHeaders: func(status int, header http.Header) (int, error) {
+			if header.Get("X-Debug") != "" {
+				return 0, errors.New("debug header set")
+			}
+			return 0, nil
+		},
+	}
+	RequestStartup(c)
+	c.Eval("header('X-Debug: secret'); echo('hello');")
+	RequestShutdown(c)
+	if recorder.Code != http.StatusInternalServerError || recorder.Header().Get("X-Debug") != "" || recorder.Body.String() != "" {
+		t.Fatalf("response not vetoed: %d %#v '%s'", recorder.Code, recorder.Header(), recorder.Body.String())
+	}
+}
+
+func Test_response_without_response_writer(t *testing.T) {
+	Initialize()
+	buffer := &bytes.Buffer{}
+	c := &Context{}
+	RequestStartup(c)
+	c.Output = buffer
+	c.Eval("header('HTTP/1.1 299 Custom'); header('X-Testing: Hello'); setcookie('name', 'value');")
+	RequestShutdown(c)
+
+	if c.Response.Status != 299 || c.Response.Reason != "Custom" || c.Response.Header.Get("X-Testing") != "Hello" {
+		t.Fatalf("unexpected response %d '%s' %#v", c.Response.Status, c.Response.Reason, c.Response.Header)
+	}
+
+	cookies := c.Response.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "name" || cookies[0].Value != "value" {
+		t.Fatalf("unexpected cookies %#v", cookies)
+	}
+}
+
+func Test_on_finish_request(t *testing.T) {
+	Initialize()
+	buffer := &bytes.Buffer{}
+	finished := false
+	c := &Context{
+		OnFinishRequest: func() {
+			finished = true
+		},
+	}
+	RequestStartup(c)
+	c.Output = buffer
+	c.Eval("echo('hello'); fastcgi_finish_request(); echo('world');")
+	RequestShutdown(c)
+
+	select {
+	case <-c.Finished():
+	default:
+		t.Fatal("Context.Finished(): channel not closed")
+	}
+
+	if !finished || buffer.String() != "hello" {
+		t.Fatalf("unexpected output '%s' for finished %v", buffer.String(), finished)
+	}
+}
+
+func Test_max_background_time(t *testing.T) {
+	Initialize()
+	if !contextInterrupt {
+		t.Skip("MaxBackgroundTime requires PHP 7.1 or later")
+	}
+
+	script, err := ioutil.TempFile("", "gophp-background")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(script.Name())
+	script.WriteString("<?php fastcgi_finish_request(); while (true) {}")
+	script.Close()
+
+	c := &Context{
+		MaxBackgroundTime: 100 * time.Millisecond,
+	}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	start := time.Now()
+	if err := c.Exec(script.Name()); err == nil {
+		t.Fatal("Context.Exec(): expected error for script exceeding background time")
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Context.Exec(): script not terminated after %s", time.Since(start))
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __CACHE_H__
+#define __CACHE_H__
+
+PHP_FUNCTION(go_cache_get);
+PHP_FUNCTION(go_cache_set);
+PHP_FUNCTION(go_cache_add);
+PHP_FUNCTION(go_cache_exists);
+PHP_FUNCTION(go_cache_delete);
+PHP_FUNCTION(go_cache_inc);
+PHP_FUNCTION(go_cache_cas);
+PHP_FUNCTION(go_cache_clear);
+
+#endif
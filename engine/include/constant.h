@@ -0,0 +1,13 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __CONSTANT_H__
+#define __CONSTANT_H__
+
+void constant_define(char *name, zval *value);
+int constant_defined(char *name);
+
+#include "_constant.h"
+
+#endif
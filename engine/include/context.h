@@ -5,20 +5,46 @@
 #ifndef __CONTEXT_H__
 #define __CONTEXT_H__
 
+// Scripts can only be interrupted from other threads as of PHP 7.1, which adds
+// the flag checked by the executor on every jump and call.
+#if PHP_VERSION_ID >= 70100
+#define CONTEXT_INTERRUPT 1
+#else
+#define CONTEXT_INTERRUPT 0
+#endif
+
 typedef struct _engine_context {
 	int is_finished;
+	int is_worker_started;
+	int uses_fs;
 	zval server_values;
 	zval query_string;
 	zval request_method;
 	zval content_type;
 	zval http_cookie;
+
+#if CONTEXT_INTERRUPT
+	// Flags for interrupting the script from other threads, which point to the
+	// executor globals of the thread the context was created on.
+	zend_bool *vm_interrupt;
+	zend_bool *timed_out;
+#endif
 } engine_context;
 
 engine_context *context_new(zval *server_values);
 void context_startup(engine_context *context);
 void context_exec(engine_context *context, char *filename);
-zval context_eval(engine_context *context, char *script);
+zval context_exec_value(engine_context *context, char *filename);
+zval context_eval(engine_context *context, char *script, char *filename);
 void context_bind(engine_context *context, char *name, zval *value);
+zval context_global(engine_context *context, char *name);
+void context_unset(engine_context *context, char *name);
+zval context_globals(engine_context *context);
+void context_skip_post_data(engine_context *context);
+void context_register_post(engine_context *context, char *name, char *value, size_t len);
+void context_register_file(engine_context *context, char *name, char *filename, char *type, char *tmp_name, int error, long size, int uploaded);
+void context_interrupt(engine_context *context);
+void context_dtor(engine_context *context);
 void context_destroy(engine_context *context);
 
 #include "_context.h"
@@ -11,6 +11,11 @@ typedef struct _php_engine {
 php_engine *engine_init(char *php_ini_path_override);
 void engine_shutdown(php_engine *engine);
 
+int engine_thread_safe();
+void engine_thread_startup();
+void engine_thread_shutdown();
+int engine_thread_current();
+
 #include "_engine.h"
 
 #endif
@@ -0,0 +1,20 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __FS_H__
+#define __FS_H__
+
+typedef struct _fs_file {
+	char *data;
+	size_t length;
+	size_t pos;
+} fs_file;
+
+void fs_init();
+void fs_exec(engine_context *context, char *filename);
+int fs_file_handle_init(zend_file_handle *handle, const char *filename, const char *opened_path, char *data, size_t length);
+
+#include "_fs.h"
+
+#endif
@@ -0,0 +1,13 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __LINT_H__
+#define __LINT_H__
+
+void lint_init();
+int lint_source(engine_context *context, char *source, size_t length, char *filename, char **message, int *line, int *column);
+
+#include "_lint.h"
+
+#endif
@@ -0,0 +1,11 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef ___CONSTANT_H___
+#define ___CONSTANT_H___
+
+static int _constant_valid(zval *value);
+static int _constant_register(char *name, zval *value);
+
+#endif
@@ -6,6 +6,11 @@
 #define ___CONTEXT_H___
 
 static void _context_bind(char *name, zval *value);
+static zval *_context_global_find(char *name);
+static void _context_global_unset(char *name);
+static void _context_global_names(zval *names);
 static void _context_eval(zend_op_array *op, zval *ret);
+static void _context_register_uploaded_file(char *tmp_name);
+static void _context_interrupt_init(engine_context *context);
 
 #endif
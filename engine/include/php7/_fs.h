@@ -0,0 +1,13 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef ___FS_H___
+#define ___FS_H___
+
+static zend_string *(*_fs_orig_resolve_path)(const char *filename, int filename_len);
+
+static zend_string *_fs_resolve_path(const char *filename, int filename_len);
+static size_t _fs_file_read(void *handle, char *buf, size_t len);
+
+#endif
@@ -0,0 +1,11 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef ___LINT_H___
+#define ___LINT_H___
+
+static void _lint_exception_error(char **message, int *line);
+static void _lint_last_error(char **message, int *line);
+
+#endif
@@ -0,0 +1,12 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef ___STREAM_H___
+#define ___STREAM_H___
+
+static size_t _stream_read(php_stream *stream, char *buf, size_t count);
+static size_t _stream_write(php_stream *stream, const char *buf, size_t count);
+static size_t _stream_dir_read(php_stream *stream, char *buf, size_t count);
+
+#endif
@@ -0,0 +1,12 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __PROGRAM_H__
+#define __PROGRAM_H__
+
+zend_op_array *program_compile(engine_context *context, char *script);
+zval program_run(engine_context *context, zend_op_array *op);
+void program_destroy(zend_op_array *op);
+
+#endif
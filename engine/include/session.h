@@ -0,0 +1,10 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __SESSION_H__
+#define __SESSION_H__
+
+void session_init();
+
+#endif
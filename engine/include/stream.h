@@ -0,0 +1,16 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __STREAM_H__
+#define __STREAM_H__
+
+typedef struct _engine_stream {
+	unsigned long id;
+} engine_stream;
+
+int stream_wrapper_register(char *scheme);
+
+#include "_stream.h"
+
+#endif
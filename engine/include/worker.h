@@ -0,0 +1,12 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#ifndef __WORKER_H__
+#define __WORKER_H__
+
+void worker_abort(engine_context *worker, engine_context *context);
+
+PHP_FUNCTION(go_handle_request);
+
+#endif
@@ -0,0 +1,122 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <errno.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <main/php.h>
+#include <zend_exceptions.h>
+
+#include "context.h"
+#include "fs.h"
+#include "lint.h"
+
+// Set while linting on the calling thread, along with the column of the token
+// last scanned when the parse error was raised.
+static __thread int lint_active = 0;
+static __thread int lint_column = 0;
+
+static zend_object *(*lint_orig_parse_error_new)(zend_class_entry *ce);
+
+// Returns the column, starting from 1, of the token last scanned, as parse errors
+// do not report columns themselves.
+static int lint_scanned_column() {
+	const unsigned char *start = LANG_SCNG(yy_start);
+	const unsigned char *text = LANG_SCNG(yy_text);
+
+	if (start == NULL || text == NULL || text < start) {
+		return 0;
+	}
+
+	const unsigned char *p = text;
+	while (p > start && p[-1] != '\n' && p[-1] != '\r') {
+		p--;
+	}
+
+	return (text - p) + 1;
+}
+
+// Creates parse errors, recording the column the error occurred at while the
+// scanner state is still available.
+static zend_object *lint_parse_error_new(zend_class_entry *ce) {
+	if (lint_active) {
+		lint_column = lint_scanned_column();
+	}
+
+	return lint_orig_parse_error_new(ce);
+}
+
+// Installs the hook recording columns for parse errors. The hook is installed
+// for all threads, and only records columns while linting.
+void lint_init() {
+	lint_orig_parse_error_new = zend_ce_parse_error->create_object;
+	zend_ce_parse_error->create_object = lint_parse_error_new;
+}
+
+// Compiles the source passed, without running it, and returns 1 if the source
+// is valid. Otherwise, the message, line and column for the first error found
+// are returned, with the message allocated for the caller to free.
+int lint_source(engine_context *context, char *source, size_t length, char *filename, char **message, int *line, int *column) {
+	zend_file_handle handle;
+	zend_op_array *op = NULL;
+	volatile int failed = 0;
+
+	*message = NULL;
+	*line = 0;
+	*column = 0;
+
+	char *data = malloc(length);
+	if (data == NULL) {
+		errno = 1;
+		return 0;
+	}
+
+	memcpy(data, source, length);
+	if (fs_file_handle_init(&handle, filename, filename, data, length) != SUCCESS) {
+		errno = 1;
+		return 0;
+	}
+
+	// Errors are reported to the caller rather than displayed or logged.
+	int error_reporting = EG(error_reporting);
+	EG(error_reporting) = 0;
+
+	lint_active = 1;
+	lint_column = 0;
+
+	// The source is compiled directly, bypassing any hooks installed by
+	// extensions such as OPcache, which would otherwise cache the result.
+	zend_try {
+		op = compile_file(&handle, ZEND_INCLUDE);
+	} zend_catch {
+		failed = 1;
+	} zend_end_try();
+
+	lint_active = 0;
+	EG(error_reporting) = error_reporting;
+
+	zend_destroy_file_handle(&handle);
+
+	if (op != NULL) {
+		destroy_op_array(op);
+		efree_size(op, sizeof(zend_op_array));
+
+		errno = 0;
+		return 1;
+	}
+
+	if (EG(exception)) {
+		_lint_exception_error(message, line);
+		*column = lint_column;
+		zend_clear_exception();
+	} else if (failed) {
+		_lint_last_error(message, line);
+	}
+
+	errno = 0;
+	return 0;
+}
+
+#include "_lint.c"
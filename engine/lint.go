@@ -0,0 +1,101 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "context.h"
+// #include "engine.h"
+// #include "lint.h"
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// SyntaxError represents an error found in PHP source code by Lint.
+type SyntaxError struct {
+	Filename string
+	Message  string
+
+	// The line and column, both starting from 1, the error occurred at. Columns
+	// are given in bytes, and are only reported for parse errors, with zero
+	// reported otherwise. Errors not related to a specific location, e.g. due
+	// to the engine being unavailable, are reported on line zero.
+	Line   int
+	Column int
+}
+
+// Error returns the error message in the format used by PHP.
+func (e *SyntaxError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("%s in %s on line %d, column %d", e.Message, e.Filename, e.Line, e.Column)
+	}
+
+	return fmt.Sprintf("%s in %s on line %d", e.Message, e.Filename, e.Line)
+}
+
+// Lint checks the PHP source code passed for errors, as reported when compiling
+// the source, without running it, similar to 'php -l'. The source is expected to
+// contain opening tags as is the case for script files, and errors are reported
+// for the filename passed. Lint returns nil for valid source code.
+//
+// As is the case for PHP, compilation stops at the first error found. Lint runs
+// a short-lived request of its own, started via RequestStartup, as errors such
+// as redeclared functions abort the request compiling the source. Lint fails
+// when called from within a request, such as from methods called by scripts,
+// rather than waiting for that request to shut down.
+//
+// As is the case for RequestStartup, Lint otherwise waits for any context
+// started via RequestStartup to be shut down, so calling Lint from a goroutine
+// holding such a context, without shutting it down first, deadlocks.
+func Lint(source string, filename string) []SyntaxError {
+	if engine == nil {
+		return []SyntaxError{{Filename: filename, Message: "engine is not initialized"}}
+	}
+
+	if C.engine_thread_current() == 1 {
+		return []SyntaxError{{Filename: filename, Message: "cannot lint from within a request"}}
+	}
+
+	ctx := &Context{}
+	if err := RequestStartup(ctx); err != nil {
+		return []SyntaxError{{Filename: filename, Message: err.Error()}}
+	}
+	defer RequestShutdown(ctx)
+
+	s := C.CString(source)
+	defer C.free(unsafe.Pointer(s))
+
+	f := C.CString(filename)
+	defer C.free(unsafe.Pointer(f))
+
+	var errs []SyntaxError
+	ctx.thread.call(func() {
+		var message *C.char
+		var line, column C.int
+
+		ok, err := C.lint_source(ctx.context, s, C.size_t(len(source)), f, &message, &line, &column)
+		if err != nil {
+			errs = []SyntaxError{{Filename: filename, Message: "failed to compile source"}}
+			return
+		}
+
+		if ok == 1 {
+			return
+		}
+
+		e := SyntaxError{Filename: filename, Line: int(line), Column: int(column)}
+		if message != nil {
+			e.Message = C.GoString(message)
+			C.free(unsafe.Pointer(message))
+		}
+
+		errs = []SyntaxError{e}
+	})
+
+	return errs
+}
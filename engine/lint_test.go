@@ -0,0 +1,70 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+var lintTests = []struct {
+	source  string
+	line    int
+	column  int
+	message string
+}{
+	{"<?php echo 'valid';", 0, 0, ""},
+	{"<?php\nfunction valid() { return 1; }\n", 0, 0, ""},
+	{"<?php\n$a = 1;\n$b = ;\n", 3, 6, "syntax error, unexpected ';'"},
+	{"<?php\nif (true) {\n", 3, 1, "syntax error, unexpected end of file"},
+	{"<?php\nfunction a() {}\nfunction a() {}\n", 3, 0, "Cannot redeclare a()"},
+}
+
+func TestLint(t *testing.T) {
+	Initialize()
+
+	for _, tt := range lintTests {
+		errs := Lint(tt.source, "test.php")
+
+		if tt.message == "" {
+			if len(errs) != 0 {
+				t.Errorf("Lint('%s'): expected no errors, actual %v", tt.source, errs)
+			}
+
+			continue
+		}
+
+		if len(errs) != 1 {
+			t.Errorf("Lint('%s'): expected 1 error, actual %d", tt.source, len(errs))
+			continue
+		}
+
+		e := errs[0]
+		if e.Line != tt.line || e.Column != tt.column || !strings.HasPrefix(e.Message, tt.message) || e.Filename != "test.php" {
+			t.Errorf("Lint('%s'): expected '%s' on line %d, column %d, actual '%s'", tt.source, tt.message, tt.line, tt.column, e.Error())
+		}
+	}
+}
+
+func TestLintWithinRequest(t *testing.T) {
+	Initialize()
+
+	c := &Context{}
+	if err := RequestStartup(c); err != nil {
+		t.Fatalf("RequestStartup(): %s", err)
+	}
+	defer RequestShutdown(c)
+
+	// Linting from within a request fails rather than waiting for the request
+	// to shut down.
+	var errs []SyntaxError
+	c.thread.call(func() {
+		errs = Lint("<?php echo 'valid';", "test.php")
+	})
+
+	if len(errs) != 1 || errs[0].Line != 0 {
+		t.Errorf("Lint(): expected error when called from within a request, actual %v", errs)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// reasonWriter is implemented by writers recording the reason phrases set by
+// scripts via e.g. header('HTTP/1.1 299 Custom'), such as the recorder used for
+// contexts without an http.ResponseWriter. As net/http offers no way of sending
+// custom reason phrases, responses sent via an http.ResponseWriter carry the
+// standard reason phrase for the status code.
+type reasonWriter interface {
+	WriteHeaderReason(statusCode int, reason string)
+}
+
+// contextOutput writes into the context's current Output, which may be replaced
+// while the request is active.
+type contextOutput struct {
+	ctx *Context
+}
+
+func (o contextOutput) Write(p []byte) (int, error) {
+	if o.ctx.Output == nil {
+		return len(p), nil
+	}
+
+	return o.ctx.Output.Write(p)
+}
+
+// newOutput returns the buffer output produced by scripts is written into for
+// the context passed, or nil if output is not buffered.
+func newOutput(ctx *Context) *bufio.Writer {
+	if ctx.OutputBufferSize <= 0 {
+		return nil
+	}
+
+	return bufio.NewWriterSize(contextOutput{ctx}, ctx.OutputBufferSize)
+}
+
+// flush writes any buffered output into the context's Output, and flushes the
+// HTTP response, if any, to the client.
+func (c *Context) flush() error {
+	if c.output != nil {
+		if err := c.output.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// writeHeader sends the response status and headers, unless already sent.
+// Informational (1xx) responses may be sent any number of times before the
+// final response.
+func (c *Context) writeHeader(status int, reason string) {
+	if c.wroteHeader {
+		return
+	}
+
+	if status >= 200 || status == http.StatusSwitchingProtocols {
+		c.wroteHeader = true
+
+		if c.OnSendHeaders != nil {
+			code, err := c.OnSendHeaders(status, c.writer.Header())
+			if err != nil {
+				c.veto(err)
+				return
+			}
+
+			if code != 0 && code != status {
+				status, reason = code, ""
+			}
+		}
+	}
+
+	if rw, ok := c.writer.(reasonWriter); ok && reason != "" {
+		rw.WriteHeaderReason(status, reason)
+		return
+	}
+
+	c.writer.WriteHeader(status)
+}
+
+// veto replaces the response with an empty '500 Internal Server Error' response,
+// and discards any further output produced by the script.
+func (c *Context) veto(err error) {
+	if c.Log != nil {
+		fmt.Fprintf(c.Log, "Response vetoed: %s\n", err)
+	}
+
+	header := c.writer.Header()
+	for k := range header {
+		delete(header, k)
+	}
+
+	c.discard = true
+	c.writer.WriteHeader(http.StatusInternalServerError)
+}
+
+// rejectBody replaces the response with a '413 Request Entity Too Large'
+// response, unless already started, once the script reads a request body larger
+// than the maximum size, and discards any further output produced by the script.
+func (c *Context) rejectBody() {
+	c.discard = true
+	if c.wroteHeader {
+		return
+	}
+
+	header := c.writer.Header()
+	for k := range header {
+		delete(header, k)
+	}
+
+	c.wroteHeader = true
+	http.Error(c.writer, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+// parseStatusLine returns the status code and reason phrase for a status line
+// of the form 'HTTP/1.1 404 Not Found'. The reason phrase is only returned if
+// the status code in the line matches the response code passed, which takes
+// precedence, as it may have been changed via http_response_code().
+func parseStatusLine(line string, code int) (int, string) {
+	parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(parts) < 2 {
+		return code, ""
+	}
+
+	status, err := strconv.Atoi(parts[1])
+	if err != nil || (code != 0 && status != code) {
+		return code, ""
+	}
+
+	if len(parts) < 3 {
+		return status, ""
+	}
+
+	return status, strings.TrimSpace(parts[2])
+}
@@ -0,0 +1,13 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+//
+// Build flags shared by the default and thread-safe (ZTS) builds of PHP7, which
+// only differ in their installation prefix, see php7-static.go and php7-zts.go.
+
+package engine
+
+// #cgo CFLAGS: -Iinclude/php7 -Isrc/php7 -Iinclude
+// #cgo LDFLAGS: -L/opt/curl/lib -L/opt/libmcrypt/lib -L/opt/zlib/lib -L/opt/openssl/lib -L/opt/libxml2/lib
+// #cgo LDFLAGS: -lphp7 -lm -ldl -lresolv -lcurl -lmcrypt -lz -lssl -lcrypto -lxml2 -lopcache
+import "C"
@@ -0,0 +1,17 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+//
+// Build tags specific to thread-safe (ZTS) builds of PHP7, which allow for
+// running requests concurrently on multiple threads via a WorkerPool. ZTS
+// builds are expected to be installed separately from the default build, as
+// thread-safety changes the ABI for PHP and its extensions.
+//
+// +build php7.zts
+
+package engine
+
+// #cgo CFLAGS: -I/opt/php-zts/include/php -I/opt/php-zts/include/php/Zend -I/opt/php-zts/include/php/TSRM -I/opt/php-zts/include/php/main
+// #cgo LDFLAGS: -L/opt/php-zts/lib -L/opt/php-zts/lib/php/extensions/debug-zts-20151012
+// #cgo LDFLAGS: -lpthread
+import "C"
@@ -0,0 +1,157 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "receiver.h"
+// #include "engine.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// WorkerPool represents a set of engine threads, each running requests
+// independently of the others. Worker pools are only available when building
+// against a thread-safe (ZTS) build of PHP, using the 'php7.zts' build tag.
+type WorkerPool struct {
+	threads []*thread
+	idle    chan *thread
+}
+
+// NewWorkerPool starts size worker threads on the active engine, and returns an
+// error if the engine is not thread-safe or if any of the threads failed to
+// start.
+func NewWorkerPool(size int) (*WorkerPool, error) {
+	if engine == nil {
+		return nil, errors.New("Cannot create worker pool without an active engine")
+	}
+
+	if C.engine_thread_safe() == 0 {
+		return nil, errors.New("Cannot create worker pool for non thread-safe PHP build")
+	}
+
+	if size < 1 {
+		return nil, fmt.Errorf("Invalid worker pool size %d", size)
+	}
+
+	p := &WorkerPool{
+		threads: make([]*thread, 0, size),
+		idle:    make(chan *thread, size),
+	}
+
+	engine.threads.Lock()
+	defer engine.threads.Unlock()
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	for i := 0; i < size; i++ {
+		t, err := newEngineThread()
+		if err != nil {
+			p.stop()
+			return nil, err
+		}
+
+		p.threads = append(p.threads, t)
+		p.idle <- t
+	}
+
+	engine.pools = append(engine.pools, p)
+
+	return p, nil
+}
+
+// Run starts a request for ctx on the next idle worker thread, calls fn and
+// shuts down the request once fn returns. Run blocks until a worker thread is
+// available.
+//
+// Values returned by PHP are bound to the worker thread they were created on,
+// and must be converted and destroyed within fn.
+func (p *WorkerPool) Run(ctx *Context, fn func(ctx *Context) error) error {
+	if err := prepareBody(ctx); err != nil {
+		return err
+	}
+
+	t := <-p.idle
+	defer func() {
+		p.idle <- t
+	}()
+
+	var err error
+	t.call(func() {
+		if err = requestStartup(ctx, t); err != nil {
+			ctx.form.remove()
+			return
+		}
+
+		ctx.thread = t
+		defer func() {
+			requestShutdown(ctx)
+			ctx.thread = nil
+		}()
+
+		err = fn(ctx)
+	})
+
+	return err
+}
+
+// Close waits for all active requests to finish and stops all worker threads.
+func (p *WorkerPool) Close() {
+	for range p.threads {
+		<-p.idle
+	}
+
+	engine.lock.Lock()
+	for i := range engine.pools {
+		if engine.pools[i] == p {
+			engine.pools = append(engine.pools[:i], engine.pools[i+1:]...)
+			break
+		}
+	}
+	engine.lock.Unlock()
+
+	p.stop()
+}
+
+func (p *WorkerPool) stop() {
+	for _, t := range p.threads {
+		stopEngineThread(t)
+	}
+
+	p.threads = nil
+}
+
+// newEngineThread starts a new thread prepared for running requests, for use in
+// thread-safe builds only. The engine lock must be held by the caller.
+func newEngineThread() (*thread, error) {
+	return newThread(func() error {
+		C.engine_thread_startup()
+
+		// Classes defined on the engine so far are not visible to newly started
+		// threads, and have to be registered for each one.
+		for name := range engine.receivers {
+			n := C.CString(name)
+			C.receiver_define(n)
+			C.free(unsafe.Pointer(n))
+		}
+
+		return nil
+	})
+}
+
+// stopEngineThread frees the resources allocated for a thread started via
+// newEngineThread, and terminates the thread.
+func stopEngineThread(t *thread) {
+	t.call(func() {
+		C.engine_thread_shutdown()
+	})
+
+	t.stop()
+}
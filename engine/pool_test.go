@@ -0,0 +1,57 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestWorkerPoolRun(t *testing.T) {
+	Initialize()
+	p, err := NewWorkerPool(4)
+	if err != nil {
+		t.Skipf("NewWorkerPool(): %s", err)
+	}
+	defer p.Close()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var w bytes.Buffer
+			c := &Context{Output: &w}
+
+			err := p.Run(c, func(c *Context) error {
+				if err := c.Bind("i", i); err != nil {
+					return err
+				}
+
+				_, err := c.Eval("echo $i;")
+				return err
+			})
+
+			if err != nil {
+				t.Errorf("WorkerPool.Run(): %s", err)
+			} else if w.String() != fmt.Sprint(i) {
+				t.Errorf("WorkerPool.Run(): expected '%d', actual '%s'", i, w.String())
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestWorkerPoolInvalidSize(t *testing.T) {
+	Initialize()
+	if _, err := NewWorkerPool(0); err == nil {
+		t.Errorf("NewWorkerPool(0): Incorrectly created empty worker pool")
+	}
+}
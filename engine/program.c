@@ -0,0 +1,81 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <errno.h>
+
+#include <main/php.h>
+#include <zend_exceptions.h>
+
+#include "context.h"
+#include "program.h"
+
+// Compiles the script passed, as is done for eval(), into an op array which may
+// be run any number of times until destroyed via program_destroy().
+zend_op_array *program_compile(engine_context *context, char *script) {
+	zend_op_array *op = NULL;
+	zval str;
+
+	ZVAL_STRING(&str, script);
+
+	uint32_t compiler_options = CG(compiler_options);
+	CG(compiler_options) = ZEND_COMPILE_DEFAULT_FOR_EVAL;
+
+	zend_try {
+		op = zend_compile_string(&str, "gophp-program");
+	} zend_end_try();
+
+	CG(compiler_options) = compiler_options;
+	zval_dtor(&str);
+
+	// Parse errors are thrown as exceptions, which are left pending when not
+	// compiling from within a running script.
+	if (op == NULL) {
+		if (EG(exception)) {
+			zend_clear_exception();
+		}
+
+		errno = 1;
+		return NULL;
+	}
+
+	errno = 0;
+	return op;
+}
+
+// Runs the op array passed in the global scope, returning the value returned by
+// the program, if any. Uncaught exceptions are reported as fatal errors.
+zval program_run(engine_context *context, zend_op_array *op) {
+	volatile int failed = 0;
+	zval result;
+
+	ZVAL_NULL(&result);
+
+	zend_try {
+		EG(no_extensions) = 1;
+		zend_execute(op, &result);
+
+		if (EG(exception)) {
+			zend_exception_error(EG(exception), E_ERROR);
+		}
+	} zend_catch {
+		failed = 1;
+	} zend_end_try();
+
+	EG(no_extensions) = 0;
+
+	if (failed) {
+		ZVAL_NULL(&result);
+
+		errno = 1;
+		return result;
+	}
+
+	errno = 0;
+	return result;
+}
+
+void program_destroy(zend_op_array *op) {
+	destroy_op_array(op);
+	efree_size(op, sizeof(zend_op_array));
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "context.h"
+// #include "engine.h"
+// #include "program.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// Program represents a PHP expression which can be run any number of times via
+// Context.Run, while being compiled only once per request.
+//
+// Compiled code is bound to the request it was compiled for in PHP, so programs
+// are compiled once for each context they are run in, on first use, and reused
+// for all subsequent runs in that context.
+type Program struct {
+	script string
+}
+
+// Compile returns a program for running the PHP expression passed via
+// Context.Run. The expression follows the same rules as for Context.Eval.
+//
+// As programs are compiled separately for each request, the expression is not
+// parsed by Compile itself, and syntax errors are reported when first running
+// the program in a context. Context.Compile can be used for checking the
+// expression ahead of running it.
+func Compile(script string) (*Program, error) {
+	if engine == nil {
+		return nil, errors.New("Cannot compile program without an active engine")
+	}
+
+	return &Program{script: script}, nil
+}
+
+// Compile compiles the PHP expression passed in the current execution context,
+// and returns a program for running the expression via Run, or an error if the
+// expression contains syntax errors. The program is reused when run in this
+// context, and compiled again when run in other contexts.
+func (c *Context) Compile(script string) (*Program, error) {
+	p := &Program{script: script}
+
+	var err error
+	c.thread.call(func() {
+		_, err = c.program(p)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Run runs the program passed in the current execution context, after binding
+// the values passed as global variables, as is done by Bind, and returns the
+// value returned by the program, if any.
+func (c *Context) Run(p *Program, bindings map[string]interface{}) (*C.struct__zval_struct, error) {
+	var result C.struct__zval_struct
+	var err error
+
+	c.thread.call(func() {
+		for name, val := range bindings {
+			var v *C.struct__zval_struct
+			if v, err = newValue(val); err != nil {
+				return
+			}
+
+			n := C.CString(name)
+			C.context_bind(c.context, n, v)
+			C.free(unsafe.Pointer(n))
+		}
+
+		var op *C.zend_op_array
+		if op, err = c.program(p); err != nil {
+			return
+		}
+
+		if result, err = C.program_run(c.context, op); err != nil {
+			err = fmt.Errorf("Error running program '%s' in context", p.script)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.body != nil && c.body.exceeded {
+		c.thread.call(func() { destroyValue(&result) })
+		return nil, ErrBodyTooLarge
+	}
+
+	return &result, nil
+}
+
+// program returns the op array compiled for the program passed in the current
+// context, compiling the program if needed.
+func (c *Context) program(p *Program) (*C.zend_op_array, error) {
+	if op, exists := c.programs[p]; exists {
+		return op, nil
+	}
+
+	s := C.CString(p.script)
+	defer C.free(unsafe.Pointer(s))
+
+	op, err := C.program_compile(c.context, s)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling program '%s'", p.script)
+	}
+
+	if c.programs == nil {
+		c.programs = make(map[*Program]*C.zend_op_array)
+	}
+
+	c.programs[p] = op
+	return op, nil
+}
+
+// destroyPrograms destroys all programs compiled for the current context, ahead
+// of shutting down the request.
+func (c *Context) destroyPrograms() {
+	for _, op := range c.programs {
+		C.program_destroy(op)
+	}
+
+	c.programs = nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	Initialize()
+
+	invalid, err := Compile("return $a +;")
+	if err != nil {
+		t.Fatalf("Compile(): %s", err)
+	}
+
+	p, err := Compile("return $a * $b;")
+	if err != nil {
+		t.Fatalf("Compile(): %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c := &Context{}
+		if err := RequestStartup(c); err != nil {
+			t.Fatalf("RequestStartup(): %s", err)
+		}
+
+		for b := 1; b <= 3; b++ {
+			val, err := c.Run(p, map[string]interface{}{"a": 2, "b": b})
+			if err != nil {
+				t.Fatalf("Context.Run(): %s", err)
+			}
+
+			if actual := ToInt(val); actual != int64(2*b) {
+				t.Errorf("Context.Run(): expected %d, actual %d", 2*b, actual)
+			}
+
+			DestroyValue(val)
+		}
+
+		if len(c.programs) != 1 {
+			t.Errorf("Context.Run(): expected 1 compiled program, actual %d", len(c.programs))
+		}
+
+		// Syntax errors are reported once the program is compiled for a context.
+		if _, err := c.Run(invalid, nil); err == nil {
+			t.Errorf("Context.Run(): expected error for invalid syntax")
+		}
+
+		RequestShutdown(c)
+	}
+}
+
+func TestContextRunError(t *testing.T) {
+	Initialize()
+	p, err := Compile("throw new Exception('failed');")
+	if err != nil {
+		t.Fatalf("Compile(): %s", err)
+	}
+
+	c := &Context{}
+	RequestStartup(c)
+	defer RequestShutdown(c)
+
+	if _, err := c.Run(p, nil); err == nil {
+		t.Errorf("Context.Run(): expected error for uncaught exception")
+	}
+}
+
+func TestContextCompile(t *testing.T) {
+	Initialize()
+
+	c := &Context{}
+	if err := RequestStartup(c); err != nil {
+		t.Fatalf("RequestStartup(): %s", err)
+	}
+	defer RequestShutdown(c)
+
+	if _, err := c.Compile("return $a +;"); err == nil {
+		t.Errorf("Context.Compile(): expected error for invalid syntax")
+	}
+
+	p, err := c.Compile("return $a * 2;")
+	if err != nil {
+		t.Fatalf("Context.Compile(): %s", err)
+	}
+
+	val, err := c.Run(p, map[string]interface{}{"a": 21})
+	if err != nil {
+		t.Fatalf("Context.Run(): %s", err)
+	}
+
+	if actual := ToInt(val); actual != 42 {
+		t.Errorf("Context.Run(): expected 42, actual %d", actual)
+	}
+
+	DestroyValue(val)
+
+	// Programs returned by Compile can be created from within a request, as no
+	// request of their own is started.
+	c.thread.call(func() {
+		p, err = Compile("return $a + 1;")
+	})
+
+	if err != nil {
+		t.Fatalf("Compile(): %s", err)
+	}
+
+	if val, err = c.Run(p, map[string]interface{}{"a": 41}); err != nil {
+		t.Fatalf("Context.Run(): %s", err)
+	}
+
+	if actual := ToInt(val); actual != 42 {
+		t.Errorf("Context.Run(): expected 42, actual %d", actual)
+	}
+
+	DestroyValue(val)
+}
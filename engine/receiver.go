@@ -68,12 +68,18 @@ func (r *Receiver) Destroy() {
 		return
 	}
 
-	n := C.CString(r.name)
-	defer C.free(unsafe.Pointer(n))
+	name := r.name
+	engine.each(func() {
+		n := C.CString(name)
+		defer C.free(unsafe.Pointer(n))
 
-	C.receiver_destroy(n)
+		C.receiver_destroy(n)
+	})
+
+	engine.lock.Lock()
 	r.create = nil
 	r.objects = nil
+	engine.lock.Unlock()
 }
 
 // ReceiverObject represents an object instance of a pre-defined method receiver.
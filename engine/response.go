@@ -0,0 +1,72 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"net/http"
+)
+
+// Response represents the response produced by scripts running in contexts
+// without an http.ResponseWriter, allowing for scripts written for HTTP requests
+// to be run offline, e.g. for pre-rendering pages, and inspected afterwards.
+type Response struct {
+	// The response status code and reason phrase, as set by the script, e.g.
+	// via header('HTTP/1.1 299 Custom'). These are set once headers are sent,
+	// which happens when the script first produces output, or when the request
+	// is shut down.
+	Status int
+	Reason string
+
+	// The response headers, as set by the script.
+	Header http.Header
+}
+
+// Cookies returns the cookies set by the script, via setcookie() or by setting
+// 'Set-Cookie' headers directly.
+func (r *Response) Cookies() []*http.Cookie {
+	return (&http.Response{Header: r.Header}).Cookies()
+}
+
+// discardWriter implements http.ResponseWriter for discarding the response of
+// requests that have already been completed.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w discardWriter) WriteHeader(code int) {}
+
+// responseRecorder implements http.ResponseWriter for recording the status and
+// headers sent into a Response. Output is written into the context's Output, and
+// never passed to the recorder.
+type responseRecorder struct {
+	response *Response
+}
+
+func (r responseRecorder) Header() http.Header {
+	return r.response.Header
+}
+
+func (r responseRecorder) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (r responseRecorder) WriteHeader(code int) {
+	r.WriteHeaderReason(code, "")
+}
+
+func (r responseRecorder) WriteHeaderReason(code int, reason string) {
+	// Informational responses are not recorded.
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		r.response.Status, r.response.Reason = code, reason
+	}
+}
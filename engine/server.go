@@ -0,0 +1,391 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The value for $_SERVER['SERVER_SOFTWARE'].
+const serverSoftware = "go-php7"
+
+// UnderscoreHeaderPolicy determines how request headers with underscores in
+// their names are registered in $_SERVER. Since both dashes and underscores map
+// to underscores in $_SERVER keys, a client may otherwise spoof headers set by
+// a proxy, e.g. by sending 'X_Forwarded_For' in addition to 'X-Forwarded-For'.
+type UnderscoreHeaderPolicy int
+
+const (
+	// Headers with underscores in their names are not registered in $_SERVER.
+	// This is the default, and matches the behaviour of most web servers.
+	DropUnderscoreHeaders UnderscoreHeaderPolicy = iota
+
+	// Headers with underscores in their names are registered in $_SERVER, but
+	// never override headers of the same name using dashes.
+	AllowUnderscoreHeaders
+)
+
+// serverVariables returns the variables registered in $_SERVER for the context's
+// HTTP request, as defined in RFC 3875 (CGI/1.1), along with the HTTP headers
+// for the request.
+func serverVariables(ctx *Context) map[string]interface{} {
+	r := ctx.Request
+	now := time.Now()
+
+	scriptName, err := filepath.Rel(ctx.DocumentRoot, ctx.ScriptFileName)
+	if err != nil {
+		scriptName = ""
+	} else {
+		scriptName = "/" + scriptName
+	}
+
+	requestURI := r.RequestURI
+	if requestURI == "" {
+		requestURI = r.URL.RequestURI()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	host := r.Host
+	remoteAddr, remotePort := splitHostPort(r.RemoteAddr)
+
+	// Requests passed through trusted proxies are registered as originally
+	// received by the proxies.
+	fwd := forwardedRequest(r, ctx.TrustedProxies)
+	if fwd != nil {
+		if fwd.addr != "" {
+			remoteAddr, remotePort = fwd.addr, fwd.port
+		}
+
+		if fwd.proto == "http" || fwd.proto == "https" {
+			scheme = fwd.proto
+		}
+
+		if fwd.host != "" {
+			host = fwd.host
+		}
+	}
+
+	serverName, serverPort := splitHostPort(host)
+	if fwd != nil && fwd.serverPort != "" {
+		serverPort = fwd.serverPort
+	}
+
+	// The server address is only known for requests received by a Go server.
+	// The local port is used unless proxies report the scheme or host the
+	// request was originally received for.
+	serverAddr := ""
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		var localPort string
+		serverAddr, localPort = splitHostPort(addr.String())
+		if serverPort == "" && (fwd == nil || (fwd.proto == "" && fwd.host == "")) {
+			serverPort = localPort
+		}
+	}
+
+	if serverPort == "" {
+		if scheme == "https" {
+			serverPort = "443"
+		} else {
+			serverPort = "80"
+		}
+	}
+
+	values := map[string]interface{}{
+		"GATEWAY_INTERFACE":  "CGI/1.1",
+		"SERVER_SOFTWARE":    serverSoftware,
+		"SERVER_PROTOCOL":    r.Proto,
+		"SERVER_NAME":        serverName,
+		"SERVER_ADDR":        serverAddr,
+		"SERVER_PORT":        serverPort,
+		"REQUEST_SCHEME":     scheme,
+		"REQUEST_URI":        requestURI,
+		"REQUEST_METHOD":     r.Method,
+		"REQUEST_TIME":       now.Unix(),
+		"REQUEST_TIME_FLOAT": float64(now.UnixNano()) / float64(time.Second),
+		"QUERY_STRING":       r.URL.RawQuery,
+		"DOCUMENT_ROOT":      ctx.DocumentRoot,
+		"SCRIPT_FILENAME":    ctx.ScriptFileName,
+		"SCRIPT_NAME":        scriptName,
+		"PHP_SELF":           scriptName + ctx.PathInfo,
+		"REMOTE_ADDR":        remoteAddr,
+		"REMOTE_PORT":        remotePort,
+		"HTTP_HOST":          host,
+	}
+
+	if scheme == "https" {
+		values["HTTPS"] = "on"
+	}
+
+	if ctx.PathInfo != "" {
+		values["PATH_INFO"] = ctx.PathInfo
+		values["PATH_TRANSLATED"] = filepath.Join(ctx.DocumentRoot, ctx.PathInfo)
+	}
+
+	for k, v := range r.Header {
+		if strings.Contains(k, "_") {
+			continue
+		}
+
+		values[headerKey(k)] = joinHeader(k, v)
+	}
+
+	if ctx.UnderscoreHeaders == AllowUnderscoreHeaders {
+		for k, v := range r.Header {
+			if _, exists := values[headerKey(k)]; exists || !strings.Contains(k, "_") {
+				continue
+			}
+
+			values[headerKey(k)] = joinHeader(k, v)
+		}
+	}
+
+	// Content type and length are passed without the 'HTTP_' prefix as well.
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		values["CONTENT_TYPE"] = contentType
+	}
+
+	// The length is taken from the request rather than the header, as it has
+	// been validated by the HTTP server. Chunked bodies have no length.
+	if r.ContentLength > 0 || r.Header.Get("Content-Length") != "" {
+		length := r.ContentLength
+		if length < 0 {
+			length = 0
+		}
+
+		values["CONTENT_LENGTH"] = length
+		values["HTTP_CONTENT_LENGTH"] = length
+	}
+
+	if ctx.OnServerValues != nil {
+		ctx.OnServerValues(values)
+	}
+
+	return values
+}
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges into a list
+// of networks usable as Context.TrustedProxies.
+func ParseTrustedProxies(proxies ...string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// forwarded represents the original request received by a proxy, as reported
+// by the proxy in the 'Forwarded' or 'X-Forwarded-*' headers.
+type forwarded struct {
+	addr       string
+	port       string
+	proto      string
+	host       string
+	serverPort string
+}
+
+// forwardedRequest returns the original request as reported by proxies, or nil
+// if the request was not received from a trusted proxy, or carries no forwarding
+// headers. The client address is the last address in the chain of proxies not
+// itself trusted, and the scheme, host and port are those reported by the proxy
+// that received the request from that address.
+func forwardedRequest(r *http.Request, trusted []*net.IPNet) *forwarded {
+	peer, _ := splitHostPort(r.RemoteAddr)
+	if !isTrusted(peer, trusted) {
+		return nil
+	}
+
+	// The standard 'Forwarded' header, as defined in RFC 7239, takes precedence
+	// over the 'X-Forwarded-*' headers.
+	if values := r.Header["Forwarded"]; len(values) > 0 {
+		var hops []map[string]string
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				hops = append(hops, parseForwardedElement(element))
+			}
+		}
+
+		fwd := &forwarded{}
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, port := splitHostPort(hops[i]["for"])
+			if net.ParseIP(addr) == nil {
+				// Obfuscated or unknown identifiers cannot be trusted.
+				break
+			}
+
+			fwd.addr, fwd.port = addr, port
+			fwd.proto, fwd.host = strings.ToLower(hops[i]["proto"]), hops[i]["host"]
+
+			if !isTrusted(addr, trusted) {
+				break
+			}
+		}
+
+		return fwd
+	}
+
+	addrs := headerValues(r.Header, "X-Forwarded-For")
+	protos := headerValues(r.Header, "X-Forwarded-Proto")
+	hosts := headerValues(r.Header, "X-Forwarded-Host")
+	ports := headerValues(r.Header, "X-Forwarded-Port")
+
+	if len(addrs) == 0 && len(protos) == 0 && len(hosts) == 0 && len(ports) == 0 {
+		return nil
+	}
+
+	// The scheme, host and port are taken from the hop the client address was
+	// selected from, as long as every proxy appended its own values.
+	hop := len(addrs) - 1
+
+	fwd := &forwarded{}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr, port := splitHostPort(addrs[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+
+		fwd.addr, fwd.port, hop = addr, port, i
+		if !isTrusted(addr, trusted) {
+			break
+		}
+	}
+
+	fwd.proto = strings.ToLower(hopValue(protos, hop, len(addrs)))
+	fwd.host = hopValue(hosts, hop, len(addrs))
+	fwd.serverPort = hopValue(ports, hop, len(addrs))
+
+	return fwd
+}
+
+// parseForwardedElement parses a single element of the 'Forwarded' header into
+// its lower-cased parameter names and unquoted values.
+func parseForwardedElement(element string) map[string]string {
+	params := make(map[string]string)
+
+	for _, pair := range strings.Split(element, ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+
+	return params
+}
+
+// headerValues returns all comma-separated values for the named header, across
+// repeated headers, in the order they were appended by proxies.
+func headerValues(h http.Header, name string) []string {
+	var values []string
+	for _, value := range h[name] {
+		for _, v := range strings.Split(value, ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+	}
+
+	return values
+}
+
+// hopValue returns the value reported for the hop passed, out of the number of
+// hops listed in 'X-Forwarded-For', if every proxy appended its value, or the
+// value appended by the closest proxy otherwise, which can always be trusted.
+func hopValue(values []string, hop, hops int) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	if len(values) == hops && hop >= 0 {
+		return values[hop]
+	}
+
+	return values[len(values)-1]
+}
+
+// isTrusted returns true if the address passed is contained in any of the
+// trusted networks.
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestHeaders returns the headers for the context's HTTP request, with
+// repeated headers joined into a single value.
+func requestHeaders(ctx *Context) map[string]string {
+	headers := make(map[string]string)
+	if ctx.Request == nil {
+		return headers
+	}
+
+	for k, v := range ctx.Request.Header {
+		headers[k] = joinHeader(k, v)
+	}
+
+	if ctx.Request.Host != "" {
+		headers["Host"] = ctx.Request.Host
+	}
+
+	return headers
+}
+
+// headerKey returns the $_SERVER key for the header name passed.
+func headerKey(name string) string {
+	return "HTTP_" + strings.Replace(strings.ToUpper(name), "-", "_", -1)
+}
+
+// joinHeader joins multiple values for the header name passed into a single
+// value, as described in RFC 7230, section 3.2.2. Cookies are joined using the
+// separator defined in RFC 6265, section 5.4.
+func joinHeader(name string, values []string) string {
+	if http.CanonicalHeaderKey(name) == "Cookie" {
+		return strings.Join(values, "; ")
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// splitHostPort splits an address of the form 'host:port' into its host and
+// port parts, handling IPv6 addresses correctly. Addresses without a port are
+// returned as-is, with an empty port.
+func splitHostPort(addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), ""
+	}
+
+	return host, port
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <errno.h>
+#include <stdlib.h>
+
+#include <main/php.h>
+#include <main/SAPI.h>
+#include <ext/session/php_session.h>
+#include <ext/session/mod_files.h>
+
+#include "context.h"
+#include "session.h"
+#include "_cgo_export.h"
+
+// The session module only writes session data for handlers with module data
+// set, which is otherwise unused by the Go session handler.
+static int session_mod_data;
+
+// Sessions opened while no session store is set are handled by the built-in
+// 'files' handler, which sets its own module data.
+#define SESSION_FILES() (PS_GET_MOD_DATA() != &session_mod_data)
+
+PS_OPEN_FUNC(go) /* {{{ */
+{
+	if (!engineSessionEnabled()) {
+		return ps_open_files(mod_data, save_path, session_name);
+	}
+
+	if (engineSessionOpen(SG(server_context), (char *) save_path, (char *) session_name) != 0) {
+		return FAILURE;
+	}
+
+	PS_SET_MOD_DATA(&session_mod_data);
+	return SUCCESS;
+}
+
+PS_CLOSE_FUNC(go) /* {{{ */
+{
+	if (SESSION_FILES()) {
+		return ps_close_files(mod_data);
+	}
+
+	PS_SET_MOD_DATA(NULL);
+	return SUCCESS;
+}
+
+PS_READ_FUNC(go) /* {{{ */
+{
+	if (SESSION_FILES()) {
+		return ps_read_files(mod_data, key, val);
+	}
+
+	char *data = NULL;
+	size_t length = 0;
+
+	if (engineSessionRead(SG(server_context), ZSTR_VAL(key), &data, &length) != 0) {
+		return FAILURE;
+	}
+
+	if (data == NULL) {
+		*val = ZSTR_EMPTY_ALLOC();
+		return SUCCESS;
+	}
+
+	*val = zend_string_init(data, length, 0);
+	free(data);
+
+	return SUCCESS;
+}
+
+PS_WRITE_FUNC(go) /* {{{ */
+{
+	if (SESSION_FILES()) {
+		return ps_write_files(mod_data, key, val);
+	}
+
+	if (engineSessionWrite(SG(server_context), ZSTR_VAL(key), ZSTR_VAL(val), ZSTR_LEN(val)) != 0) {
+		return FAILURE;
+	}
+
+	return SUCCESS;
+}
+
+PS_DESTROY_FUNC(go) /* {{{ */
+{
+	if (SESSION_FILES()) {
+		return ps_delete_files(mod_data, key);
+	}
+
+	if (engineSessionDestroy(SG(server_context), ZSTR_VAL(key)) != 0) {
+		return FAILURE;
+	}
+
+	return SUCCESS;
+}
+
+PS_GC_FUNC(go) /* {{{ */
+{
+	if (SESSION_FILES()) {
+		return ps_gc_files(mod_data, maxlifetime, nrdels);
+	}
+
+	int deleted = engineSessionGC(SG(server_context), maxlifetime);
+	if (deleted < 0) {
+		return FAILURE;
+	}
+
+	*nrdels = deleted;
+	return SUCCESS;
+}
+
+static ps_module ps_mod_go = {
+	PS_MOD(go)
+};
+
+// Registers the Go session handler with the session module under the name 'go'.
+// This is called once, ahead of starting up the session module, which selects
+// the handler set via 'session.save_handler'.
+void session_init() {
+	php_session_register_module(&ps_mod_go);
+}
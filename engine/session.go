@@ -0,0 +1,254 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "context.h"
+// #include "session.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// SessionStore represents a storage backend for PHP sessions, as used by the
+// session handler registered via SetSessionHandler. Methods are called on the
+// engine thread running the request, and therefore concurrently when using a
+// WorkerPool.
+type SessionStore interface {
+	// Open is called when a session is started, with the values of the
+	// 'session.save_path' and 'session.name' settings.
+	Open(savePath, name string) error
+
+	// Read returns the serialized data for the session ID passed, or nil if no
+	// such session exists.
+	Read(id string) ([]byte, error)
+
+	// Write stores the serialized data for the session ID passed.
+	Write(id string, data []byte) error
+
+	// Destroy removes the session for the ID passed, e.g. when calling
+	// session_destroy() in PHP.
+	Destroy(id string) error
+
+	// GC removes sessions not written to for longer than the maximum lifetime
+	// passed, and returns the number of sessions removed.
+	GC(maxLifetime time.Duration) (int, error)
+}
+
+// SetSessionHandler sets the session store passed as the store for PHP sessions
+// handled by the 'go' session handler, which is the default handler unless set
+// otherwise in php.ini, for all sessions started subsequently. Passing nil
+// restores the built-in 'files' handler. Scripts may still select other
+// handlers via 'session.save_handler' or session_set_save_handler().
+func SetSessionHandler(store SessionStore) error {
+	if engine == nil {
+		return errors.New("Cannot set session handler without an active engine")
+	}
+
+	engine.lock.Lock()
+	engine.sessions = store
+	engine.lock.Unlock()
+
+	return nil
+}
+
+// MemorySessionStore is a SessionStore keeping sessions in memory, which allows
+// for sharing sessions with Go handlers in the same process, e.g. via Read and
+// Write, as well as for testing.
+type MemorySessionStore struct {
+	lock     sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	data    []byte
+	written time.Time
+}
+
+// NewMemorySessionStore returns an empty in-memory session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Open implements SessionStore, and does nothing for in-memory stores.
+func (s *MemorySessionStore) Open(savePath, name string) error {
+	return nil
+}
+
+// Read returns a copy of the data stored for the session ID passed, or nil if
+// no such session exists.
+func (s *MemorySessionStore) Read(id string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return append([]byte(nil), session.data...), nil
+}
+
+// Write stores a copy of the data passed for the session ID passed.
+func (s *MemorySessionStore) Write(id string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sessions[id] = memorySession{
+		data:    append([]byte(nil), data...),
+		written: time.Now(),
+	}
+
+	return nil
+}
+
+// Destroy removes the session for the ID passed, if any.
+func (s *MemorySessionStore) Destroy(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// GC removes sessions not written to for longer than the maximum lifetime
+// passed.
+func (s *MemorySessionStore) GC(maxLifetime time.Duration) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	deleted := 0
+	for id, session := range s.sessions {
+		if time.Since(session.written) > maxLifetime {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// sessionStore returns the active session store, logging an error to the
+// context passed if none is set.
+func sessionStore(ctx *C.struct__engine_context) SessionStore {
+	engine.lock.RLock()
+	store := engine.sessions
+	engine.lock.RUnlock()
+
+	if store == nil {
+		sessionError(ctx, errors.New("no session store set"))
+	}
+
+	return store
+}
+
+// sessionError logs errors returned by the session store to the context's log.
+func sessionError(ctx *C.struct__engine_context, err error) {
+	if context := engine.context(ctx); context != nil && context.Log != nil {
+		fmt.Fprintf(context.Log, "Session handler failed: %s\n", err)
+	}
+}
+
+//export engineSessionEnabled
+func engineSessionEnabled() C.int {
+	engine.lock.RLock()
+	defer engine.lock.RUnlock()
+
+	if engine.sessions == nil {
+		return 0
+	}
+
+	return 1
+}
+
+//export engineSessionOpen
+func engineSessionOpen(ctx *C.struct__engine_context, savePath *C.char, name *C.char) C.int {
+	store := sessionStore(ctx)
+	if store == nil {
+		return -1
+	}
+
+	if err := store.Open(C.GoString(savePath), C.GoString(name)); err != nil {
+		sessionError(ctx, err)
+		return -1
+	}
+
+	return 0
+}
+
+//export engineSessionRead
+func engineSessionRead(ctx *C.struct__engine_context, id *C.char, data **C.char, length *C.size_t) C.int {
+	store := sessionStore(ctx)
+	if store == nil {
+		return -1
+	}
+
+	val, err := store.Read(C.GoString(id))
+	if err != nil {
+		sessionError(ctx, err)
+		return -1
+	}
+
+	// The data is copied into memory owned by the caller, which frees it once
+	// copied into a PHP string.
+	if len(val) > 0 {
+		*data = (*C.char)(C.CBytes(val))
+		*length = C.size_t(len(val))
+	}
+
+	return 0
+}
+
+//export engineSessionWrite
+func engineSessionWrite(ctx *C.struct__engine_context, id *C.char, data *C.char, length C.size_t) C.int {
+	store := sessionStore(ctx)
+	if store == nil {
+		return -1
+	}
+
+	if err := store.Write(C.GoString(id), C.GoBytes(unsafe.Pointer(data), C.int(length))); err != nil {
+		sessionError(ctx, err)
+		return -1
+	}
+
+	return 0
+}
+
+//export engineSessionDestroy
+func engineSessionDestroy(ctx *C.struct__engine_context, id *C.char) C.int {
+	store := sessionStore(ctx)
+	if store == nil {
+		return -1
+	}
+
+	if err := store.Destroy(C.GoString(id)); err != nil {
+		sessionError(ctx, err)
+		return -1
+	}
+
+	return 0
+}
+
+//export engineSessionGC
+func engineSessionGC(ctx *C.struct__engine_context, maxLifetime C.long) C.int {
+	store := sessionStore(ctx)
+	if store == nil {
+		return -1
+	}
+
+	deleted, err := store.GC(time.Duration(maxLifetime) * time.Second)
+	if err != nil {
+		sessionError(ctx, err)
+		return -1
+	}
+
+	return C.int(deleted)
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var sessionScript = `session_start();
+$_SESSION['count'] = isset($_SESSION['count']) ? $_SESSION['count'] + 1 : 1;
+echo $_SESSION['count'];`
+
+func TestSessionHandler(t *testing.T) {
+	Initialize()
+	store := NewMemorySessionStore()
+	if err := SetSessionHandler(store); err != nil {
+		t.Fatalf("SetSessionHandler(): %s", err)
+	}
+	defer SetSessionHandler(nil)
+
+	for _, expected := range []string{"1", "2"} {
+		var w bytes.Buffer
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Cookie", "PHPSESSID=gophpsession")
+
+		c := &Context{Request: r, Output: &w}
+		if err := RequestStartup(c); err != nil {
+			t.Fatalf("RequestStartup(): %s", err)
+		}
+
+		if _, err := c.Eval(sessionScript); err != nil {
+			t.Errorf("Context.Eval(): %s", err)
+		}
+
+		RequestShutdown(c)
+
+		if w.String() != expected {
+			t.Errorf("Context.Eval(): expected session count '%s', actual '%s'", expected, w.String())
+		}
+	}
+
+	data, err := store.Read("gophpsession")
+	if err != nil || string(data) != "count|i:2;" {
+		t.Errorf("MemorySessionStore.Read(): expected 'count|i:2;', actual '%s' (%v)", data, err)
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := NewMemorySessionStore()
+
+	if data, err := store.Read("missing"); data != nil || err != nil {
+		t.Errorf("MemorySessionStore.Read(): expected no data for missing session, actual '%s' (%v)", data, err)
+	}
+
+	store.Write("a", []byte("a|i:1;"))
+	store.Write("b", []byte("b|i:1;"))
+	store.Destroy("b")
+
+	if data, _ := store.Read("b"); data != nil {
+		t.Errorf("MemorySessionStore.Destroy(): session not removed")
+	}
+
+	if deleted, _ := store.GC(time.Hour); deleted != 0 {
+		t.Errorf("MemorySessionStore.GC(): expected no sessions removed, actual %d", deleted)
+	}
+
+	if deleted, _ := store.GC(0); deleted != 1 {
+		t.Errorf("MemorySessionStore.GC(): expected 1 session removed, actual %d", deleted)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+static int _constant_valid(zval *value) {
+	zval *val;
+
+	switch (Z_TYPE_P(value)) {
+	case IS_NULL:
+	case IS_FALSE:
+	case IS_TRUE:
+	case IS_LONG:
+	case IS_DOUBLE:
+	case IS_STRING:
+		return 1;
+	case IS_ARRAY:
+		ZEND_HASH_FOREACH_VAL(Z_ARRVAL_P(value), val) {
+			ZVAL_DEREF(val);
+			if (!_constant_valid(val)) {
+				return 0;
+			}
+		} ZEND_HASH_FOREACH_END();
+
+		return 1;
+	}
+
+	return 0;
+}
+
+static int _constant_register(char *name, zval *value) {
+	zend_constant c;
+
+	// Constants already defined are not overwritten, and a notice is raised, as
+	// is done for define().
+	ZVAL_COPY(&c.value, value);
+	c.flags = CONST_CS;
+	c.name = zend_string_init(name, strlen(name), 0);
+	c.module_number = PHP_USER_CONSTANT;
+
+	return zend_register_constant(&c) == SUCCESS;
+}
@@ -6,6 +6,43 @@ static void _context_bind(char *name, zval *value) {
 	zend_hash_str_update(&EG(symbol_table), name, strlen(name), value);
 }
 
+static zval *_context_global_find(char *name) {
+	zval *val = zend_hash_str_find(&EG(symbol_table), name, strlen(name));
+
+	// Globals used by scripts may be stored indirectly, and are left undefined
+	// once unset.
+	if (val != NULL && Z_TYPE_P(val) == IS_INDIRECT) {
+		val = Z_INDIRECT_P(val);
+	}
+
+	if (val == NULL || Z_TYPE_P(val) == IS_UNDEF) {
+		return NULL;
+	}
+
+	ZVAL_DEREF(val);
+	return val;
+}
+
+static void _context_global_unset(char *name) {
+	zend_hash_str_del_ind(&EG(symbol_table), name, strlen(name));
+}
+
+static void _context_global_names(zval *names) {
+	zend_string *key;
+	zval *val;
+
+	array_init(names);
+
+	ZEND_HASH_FOREACH_STR_KEY_VAL_IND(&EG(symbol_table), key, val) {
+		// Superglobals, including $GLOBALS itself, are not listed.
+		if (key == NULL || Z_TYPE_P(val) == IS_UNDEF || zend_hash_exists(CG(auto_globals), key)) {
+			continue;
+		}
+
+		add_next_index_str(names, zend_string_copy(key));
+	} ZEND_HASH_FOREACH_END();
+}
+
 static void _context_eval(zend_op_array *op, zval *ret) {
 	EG(no_extensions) = 1;
 
@@ -23,3 +60,26 @@ static void _context_eval(zend_op_array *op, zval *ret) {
 
 	EG(no_extensions) = 0;
 }
+
+static void _context_free_uploaded_file(zval *el) {
+	zend_string_release((zend_string *) Z_PTR_P(el));
+}
+
+static void _context_register_uploaded_file(char *tmp_name) {
+	// The list of uploaded files is destroyed, and any files remaining in it are
+	// removed, on request shutdown.
+	if (SG(rfc1867_uploaded_files) == NULL) {
+		ALLOC_HASHTABLE(SG(rfc1867_uploaded_files));
+		zend_hash_init(SG(rfc1867_uploaded_files), 8, NULL, _context_free_uploaded_file, 0);
+	}
+
+	zend_string *name = zend_string_init(tmp_name, strlen(tmp_name), 0);
+	zend_hash_add_ptr(SG(rfc1867_uploaded_files), name, name);
+}
+
+static void _context_interrupt_init(engine_context *context) {
+#if CONTEXT_INTERRUPT
+	context->vm_interrupt = &EG(vm_interrupt);
+	context->timed_out = &EG(timed_out);
+#endif
+}
@@ -0,0 +1,15 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+static zend_string *_fs_resolve_path(const char *filename, int filename_len) {
+	if (!fs_active(filename)) {
+		return _fs_orig_resolve_path(filename, filename_len);
+	}
+
+	return fs_resolve_path(filename);
+}
+
+static size_t _fs_file_read(void *handle, char *buf, size_t len) {
+	return fs_file_read((fs_file *) handle, buf, len);
+}
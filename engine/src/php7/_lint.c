@@ -0,0 +1,27 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+static void _lint_exception_error(char **message, int *line) {
+	zval ex, rv;
+
+	ZVAL_OBJ(&ex, EG(exception));
+
+	zval *msg = zend_read_property(zend_ce_error, &ex, "message", sizeof("message") - 1, 1, &rv);
+	if (msg && Z_TYPE_P(msg) == IS_STRING) {
+		*message = strdup(Z_STRVAL_P(msg));
+	}
+
+	zval *lineno = zend_read_property(zend_ce_error, &ex, "line", sizeof("line") - 1, 1, &rv);
+	if (lineno && Z_TYPE_P(lineno) == IS_LONG) {
+		*line = Z_LVAL_P(lineno);
+	}
+}
+
+static void _lint_last_error(char **message, int *line) {
+	if (PG(last_error_message)) {
+		*message = strdup(PG(last_error_message));
+	}
+
+	*line = PG(last_error_lineno);
+}
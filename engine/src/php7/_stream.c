@@ -0,0 +1,17 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+static size_t _stream_read(php_stream *stream, char *buf, size_t count) {
+	int read = stream_read(stream, buf, count);
+	return (read < 0) ? 0 : read;
+}
+
+static size_t _stream_write(php_stream *stream, const char *buf, size_t count) {
+	int written = stream_write(stream, buf, count);
+	return (written < 0) ? 0 : written;
+}
+
+static size_t _stream_dir_read(php_stream *stream, char *buf, size_t count) {
+	return stream_dir_read(stream, buf, count);
+}
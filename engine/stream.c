@@ -0,0 +1,213 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <errno.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <main/php.h>
+#include <main/php_streams.h>
+
+#include "stream.h"
+#include "_cgo_export.h"
+
+static int stream_read(php_stream *stream, char *buf, size_t count) {
+	engine_stream *s = (engine_stream *) stream->abstract;
+
+	int read = engineStreamRead(s->id, buf, count);
+	if (read <= 0) {
+		stream->eof = 1;
+	}
+
+	return read;
+}
+
+static int stream_write(php_stream *stream, const char *buf, size_t count) {
+	engine_stream *s = (engine_stream *) stream->abstract;
+	return engineStreamWrite(s->id, (char *) buf, count);
+}
+
+static int stream_close(php_stream *stream, int close_handle) {
+	engine_stream *s = (engine_stream *) stream->abstract;
+	int ret = engineStreamClose(s->id);
+
+	efree(s);
+	return ret;
+}
+
+static int stream_flush(php_stream *stream) {
+	return 0;
+}
+
+static int stream_seek(php_stream *stream, zend_off_t offset, int whence, zend_off_t *newoffset) {
+	engine_stream *s = (engine_stream *) stream->abstract;
+	long pos = 0;
+
+	if (engineStreamSeek(s->id, offset, whence, &pos) != 0) {
+		return -1;
+	}
+
+	*newoffset = pos;
+	return 0;
+}
+
+// Fills the stat buffer passed with the mode, size and modification time, as
+// reported by the Go stream wrapper.
+static void stream_fill_stat(php_stream_statbuf *ssb, int mode, long size, long mtime) {
+	memset(ssb, 0, sizeof(php_stream_statbuf));
+
+	ssb->sb.st_mode = mode;
+	ssb->sb.st_size = size;
+	ssb->sb.st_mtime = mtime;
+	ssb->sb.st_nlink = 1;
+}
+
+static int stream_stat(php_stream *stream, php_stream_statbuf *ssb) {
+	engine_stream *s = (engine_stream *) stream->abstract;
+	int mode = 0;
+	long size = 0, mtime = 0;
+
+	if (engineStreamFstat(s->id, &mode, &size, &mtime) != 0) {
+		return -1;
+	}
+
+	stream_fill_stat(ssb, mode, size, mtime);
+	return 0;
+}
+
+static php_stream_ops stream_ops = {
+	_stream_write,
+	_stream_read,
+	stream_close,
+	stream_flush,
+	"go",
+	stream_seek,
+	NULL, // Cast
+	stream_stat,
+	NULL, // Set Option
+};
+
+static int stream_dir_read(php_stream *stream, char *buf, size_t count) {
+	engine_stream *s = (engine_stream *) stream->abstract;
+	php_stream_dirent *ent = (php_stream_dirent *) buf;
+
+	if (count != sizeof(php_stream_dirent)) {
+		return 0;
+	}
+
+	if (engineStreamReadDir(s->id, ent->d_name, sizeof(ent->d_name)) != 1) {
+		stream->eof = 1;
+		return 0;
+	}
+
+	return sizeof(php_stream_dirent);
+}
+
+static int stream_dir_rewind(php_stream *stream, zend_off_t offset, int whence, zend_off_t *newoffset) {
+	engine_stream *s = (engine_stream *) stream->abstract;
+
+	engineStreamRewindDir(s->id);
+	*newoffset = 0;
+
+	return 0;
+}
+
+static php_stream_ops stream_dir_ops = {
+	NULL, // Write
+	_stream_dir_read,
+	stream_close,
+	NULL, // Flush
+	"go dir",
+	stream_dir_rewind,
+	NULL, // Cast
+	NULL, // Stat
+	NULL, // Set Option
+};
+
+static php_stream *stream_open(php_stream_wrapper *wrapper, const char *filename, const char *mode, int options, zend_string **opened_path, php_stream_context *context STREAMS_DC) {
+	unsigned long id = 0;
+
+	char *err = engineStreamOpen((char *) filename, (char *) mode, &id);
+	if (err != NULL) {
+		php_stream_wrapper_log_error(wrapper, options, "%s", err);
+		free(err);
+		return NULL;
+	}
+
+	engine_stream *s = emalloc(sizeof(engine_stream));
+	s->id = id;
+
+	if (opened_path) {
+		*opened_path = zend_string_init(filename, strlen(filename), 0);
+	}
+
+	return php_stream_alloc_rel(&stream_ops, s, NULL, mode);
+}
+
+static int stream_url_stat(php_stream_wrapper *wrapper, const char *url, int flags, php_stream_statbuf *ssb, php_stream_context *context) {
+	int mode = 0;
+	long size = 0, mtime = 0;
+
+	if (engineStreamStat((char *) url, &mode, &size, &mtime) != 0) {
+		return -1;
+	}
+
+	stream_fill_stat(ssb, mode, size, mtime);
+	return 0;
+}
+
+static php_stream *stream_dir_open(php_stream_wrapper *wrapper, const char *filename, const char *mode, int options, zend_string **opened_path, php_stream_context *context STREAMS_DC) {
+	unsigned long id = 0;
+
+	char *err = engineStreamOpenDir((char *) filename, &id);
+	if (err != NULL) {
+		php_stream_wrapper_log_error(wrapper, options, "%s", err);
+		free(err);
+		return NULL;
+	}
+
+	engine_stream *s = emalloc(sizeof(engine_stream));
+	s->id = id;
+
+	return php_stream_alloc_rel(&stream_dir_ops, s, NULL, mode);
+}
+
+static php_stream_wrapper_ops stream_wrapper_ops = {
+	stream_open,
+	NULL, // Close
+	NULL, // Stat
+	stream_url_stat,
+	stream_dir_open,
+	"go",
+	NULL, // Unlink
+	NULL, // Rename
+	NULL, // Mkdir
+	NULL, // Rmdir
+	NULL, // Metadata
+};
+
+// Registers the Go stream wrapper for the scheme passed. Wrappers are registered
+// for the process as a whole, and are never unregistered.
+int stream_wrapper_register(char *scheme) {
+	php_stream_wrapper *wrapper = malloc(sizeof(php_stream_wrapper));
+	if (wrapper == NULL) {
+		errno = 1;
+		return 1;
+	}
+
+	wrapper->wops = &stream_wrapper_ops;
+	wrapper->abstract = NULL;
+	wrapper->is_url = 0;
+
+	if (php_register_url_stream_wrapper(scheme, wrapper) != SUCCESS) {
+		free(wrapper);
+		errno = 1;
+		return 1;
+	}
+
+	errno = 0;
+	return 0;
+}
+
+#include "_stream.c"
@@ -0,0 +1,381 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "stream.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"unsafe"
+)
+
+// The file mode bits for directories and regular files, as reported to PHP.
+const (
+	streamModeDir  = 0040000
+	streamModeFile = 0100000
+)
+
+// StreamWrapper represents a storage backend for files accessed by PHP under a
+// custom URL scheme, as registered via RegisterStreamWrapper. Names passed are
+// the part of the URL following the scheme, e.g. 'dir/file.php' for the URL
+// 'tenant://dir/file.php'.
+//
+// Methods are called on the engine thread running the request, and therefore
+// concurrently when using a WorkerPool.
+type StreamWrapper interface {
+	// Open opens the named file for the mode passed, as passed to fopen() in
+	// PHP, e.g. 'rb'. Files opened for writing must implement io.Writer, and
+	// files implementing io.Seeker can be seeked in PHP.
+	Open(name string, mode string) (io.ReadCloser, error)
+
+	// Stat returns information on the named file or directory.
+	Stat(name string) (os.FileInfo, error)
+
+	// ReadDir returns the names of all entries in the named directory.
+	ReadDir(name string) ([]string, error)
+}
+
+// RegisterStreamWrapper registers the stream wrapper passed for the URL scheme
+// passed, making files provided by the wrapper available to all PHP functions
+// operating on streams, e.g. fopen(), file_get_contents(), include and opendir().
+//
+// Schemes are case-insensitive, and may only be registered once. Stream wrappers
+// are registered for all threads at once, and therefore cannot be registered
+// while any WorkerPool or Worker is running.
+func RegisterStreamWrapper(scheme string, wrapper StreamWrapper) error {
+	if engine == nil {
+		return errors.New("Cannot register stream wrapper without an active engine")
+	}
+
+	scheme = strings.ToLower(scheme)
+	if scheme == "" || strings.Trim(scheme, "abcdefghijklmnopqrstuvwxyz0123456789+-.") != "" {
+		return fmt.Errorf("Invalid stream wrapper scheme '%s'", scheme)
+	}
+
+	engine.threads.Lock()
+	defer engine.threads.Unlock()
+
+	engine.lock.Lock()
+	if len(engine.pools) > 0 || len(engine.workers) > 0 {
+		engine.lock.Unlock()
+		return fmt.Errorf("Cannot register stream wrapper '%s' while worker pools or workers are running", scheme)
+	}
+
+	if _, exists := engine.wrappers[scheme]; exists {
+		engine.lock.Unlock()
+		return fmt.Errorf("Failed to register duplicate stream wrapper '%s'", scheme)
+	}
+
+	engine.wrappers[scheme] = wrapper
+	engine.lock.Unlock()
+
+	s := C.CString(scheme)
+	defer C.free(unsafe.Pointer(s))
+
+	// Stream wrappers are registered for all threads at once, while no other
+	// threads may be reading them.
+	var err error
+	engine.thread.call(func() {
+		_, err = C.stream_wrapper_register(s)
+	})
+
+	if err != nil {
+		engine.lock.Lock()
+		delete(engine.wrappers, scheme)
+		engine.lock.Unlock()
+
+		return fmt.Errorf("Failed to register stream wrapper '%s'", scheme)
+	}
+
+	return nil
+}
+
+// NewFSStreamWrapper returns a read-only stream wrapper serving files from the
+// file system passed, e.g. an embed.FS.
+func NewFSStreamWrapper(fsys fs.FS) StreamWrapper {
+	return fsStreamWrapper{fsys}
+}
+
+type fsStreamWrapper struct {
+	fsys fs.FS
+}
+
+func (w fsStreamWrapper) Open(name string, mode string) (io.ReadCloser, error) {
+	if strings.ContainsAny(mode, "waxc+") {
+		return nil, errors.New("file system is read-only")
+	}
+
+	return w.fsys.Open(fsName(name))
+}
+
+func (w fsStreamWrapper) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(w.fsys, fsName(name))
+}
+
+func (w fsStreamWrapper) ReadDir(name string) ([]string, error) {
+	entries, err := fs.ReadDir(w.fsys, fsName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+
+	return names, nil
+}
+
+// fsName returns the name passed as a valid path for io/fs file systems, which
+// are always relative to the root of the file system.
+func fsName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// streamHandle represents a file or directory opened via a stream wrapper.
+type streamHandle struct {
+	wrapper StreamWrapper
+	name    string
+	file    io.ReadCloser
+	entries []string
+	next    int
+}
+
+// streamURL returns the wrapper registered for the URL passed, along with the
+// name of the file the URL refers to.
+func streamURL(url string) (StreamWrapper, string, error) {
+	parts := strings.SplitN(url, "://", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("Invalid URL '%s'", url)
+	}
+
+	engine.lock.RLock()
+	wrapper := engine.wrappers[strings.ToLower(parts[0])]
+	engine.lock.RUnlock()
+
+	if wrapper == nil {
+		return nil, "", fmt.Errorf("No stream wrapper registered for '%s'", parts[0])
+	}
+
+	return wrapper, parts[1], nil
+}
+
+// newStream registers the handle passed, and returns its ID.
+func newStream(h *streamHandle) C.ulong {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.streamID++
+	engine.streams[engine.streamID] = h
+
+	return engine.streamID
+}
+
+func stream(id C.ulong) *streamHandle {
+	engine.lock.RLock()
+	defer engine.lock.RUnlock()
+
+	return engine.streams[id]
+}
+
+// streamStat converts the file information passed to the values reported to PHP.
+func streamStat(info os.FileInfo, mode *C.int, size *C.long, mtime *C.long) {
+	m := C.int(info.Mode().Perm())
+	if info.IsDir() {
+		m |= streamModeDir
+	} else {
+		m |= streamModeFile
+	}
+
+	*mode, *size, *mtime = m, C.long(info.Size()), C.long(info.ModTime().Unix())
+}
+
+//export engineStreamOpen
+func engineStreamOpen(url *C.char, mode *C.char, id *C.ulong) *C.char {
+	wrapper, name, err := streamURL(C.GoString(url))
+	if err != nil {
+		return C.CString(err.Error())
+	}
+
+	file, err := wrapper.Open(name, C.GoString(mode))
+	if err != nil {
+		return C.CString(err.Error())
+	}
+
+	*id = newStream(&streamHandle{wrapper: wrapper, name: name, file: file})
+	return nil
+}
+
+//export engineStreamRead
+func engineStreamRead(id C.ulong, buffer *C.char, length C.size_t) C.int {
+	h := stream(id)
+	if h == nil || h.file == nil {
+		return -1
+	}
+
+	n, err := h.file.Read(unsafe.Slice((*byte)(unsafe.Pointer(buffer)), int(length)))
+	if n == 0 && err != nil && err != io.EOF {
+		return -1
+	}
+
+	return C.int(n)
+}
+
+//export engineStreamWrite
+func engineStreamWrite(id C.ulong, buffer *C.char, length C.size_t) C.int {
+	h := stream(id)
+	if h == nil {
+		return -1
+	}
+
+	w, ok := h.file.(io.Writer)
+	if !ok {
+		return -1
+	}
+
+	n, err := w.Write(C.GoBytes(unsafe.Pointer(buffer), C.int(length)))
+	if n == 0 && err != nil {
+		return -1
+	}
+
+	return C.int(n)
+}
+
+//export engineStreamSeek
+func engineStreamSeek(id C.ulong, offset C.long, whence C.int, pos *C.long) C.int {
+	h := stream(id)
+	if h == nil {
+		return -1
+	}
+
+	s, ok := h.file.(io.Seeker)
+	if !ok {
+		return -1
+	}
+
+	n, err := s.Seek(int64(offset), int(whence))
+	if err != nil {
+		return -1
+	}
+
+	*pos = C.long(n)
+	return 0
+}
+
+//export engineStreamFstat
+func engineStreamFstat(id C.ulong, mode *C.int, size *C.long, mtime *C.long) C.int {
+	h := stream(id)
+	if h == nil {
+		return -1
+	}
+
+	var info os.FileInfo
+	var err error
+
+	// Files opened are stat'ed directly where possible, e.g. for files opened on
+	// an io/fs file system.
+	if f, ok := h.file.(interface{ Stat() (os.FileInfo, error) }); ok {
+		info, err = f.Stat()
+	} else {
+		info, err = h.wrapper.Stat(h.name)
+	}
+
+	if err != nil {
+		return -1
+	}
+
+	streamStat(info, mode, size, mtime)
+	return 0
+}
+
+//export engineStreamStat
+func engineStreamStat(url *C.char, mode *C.int, size *C.long, mtime *C.long) C.int {
+	wrapper, name, err := streamURL(C.GoString(url))
+	if err != nil {
+		return -1
+	}
+
+	info, err := wrapper.Stat(name)
+	if err != nil {
+		return -1
+	}
+
+	streamStat(info, mode, size, mtime)
+	return 0
+}
+
+//export engineStreamOpenDir
+func engineStreamOpenDir(url *C.char, id *C.ulong) *C.char {
+	wrapper, name, err := streamURL(C.GoString(url))
+	if err != nil {
+		return C.CString(err.Error())
+	}
+
+	entries, err := wrapper.ReadDir(name)
+	if err != nil {
+		return C.CString(err.Error())
+	}
+
+	*id = newStream(&streamHandle{wrapper: wrapper, name: name, entries: entries})
+	return nil
+}
+
+//export engineStreamReadDir
+func engineStreamReadDir(id C.ulong, buffer *C.char, length C.size_t) C.int {
+	h := stream(id)
+	if h == nil || h.next >= len(h.entries) {
+		return 0
+	}
+
+	name := h.entries[h.next]
+	h.next++
+
+	// Names are truncated to fit the buffer, leaving space for the terminating
+	// null byte.
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(buffer)), int(length))
+	n := copy(buf[:len(buf)-1], name)
+	buf[n] = 0
+
+	return 1
+}
+
+//export engineStreamRewindDir
+func engineStreamRewindDir(id C.ulong) {
+	if h := stream(id); h != nil {
+		h.next = 0
+	}
+}
+
+//export engineStreamClose
+func engineStreamClose(id C.ulong) C.int {
+	engine.lock.Lock()
+	h := engine.streams[id]
+	delete(engine.streams, id)
+	engine.lock.Unlock()
+
+	if h == nil || h.file == nil {
+		return 0
+	}
+
+	if err := h.file.Close(); err != nil {
+		return -1
+	}
+
+	return 0
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+var streamFiles = fstest.MapFS{
+	"config.txt":      {Data: []byte("hello")},
+	"lib/greet.php":   {Data: []byte("<?php return 'greeting from ' . __FILE__;")},
+	"lib/helpers.php": {Data: []byte("<?php")},
+}
+
+func TestStreamWrapper(t *testing.T) {
+	Initialize()
+	if err := RegisterStreamWrapper("gotest", NewFSStreamWrapper(streamFiles)); err != nil {
+		t.Fatalf("RegisterStreamWrapper(): %s", err)
+	}
+
+	if err := RegisterStreamWrapper("gotest", NewFSStreamWrapper(streamFiles)); err == nil {
+		t.Errorf("RegisterStreamWrapper(): registered duplicate scheme")
+	}
+
+	evalAssert(&Context{}, `
+		$f = fopen('gotest://config.txt', 'r');
+		$first = fread($f, 2);
+		fseek($f, 1);
+		$seeked = stream_get_contents($f);
+		fclose($f);
+
+		$entries = [];
+		$dir = opendir('gotest://lib');
+		while (($entry = readdir($dir)) !== false) {
+			$entries[] = $entry;
+		}
+		closedir($dir);
+		sort($entries);
+
+		return [
+			file_get_contents('gotest://config.txt'),
+			$first,
+			$seeked,
+			filesize('gotest://config.txt'),
+			is_dir('gotest://lib'),
+			file_exists('gotest://missing.txt'),
+			include 'gotest://lib/greet.php',
+			implode(',', $entries),
+			@fopen('gotest://config.txt', 'w') === false,
+		];`, func(val evalAssertionArg) {
+		expected := []interface{}{
+			"hello",
+			"he",
+			"ello",
+			int64(5),
+			true,
+			false,
+			"greeting from gotest://lib/greet.php",
+			"greet.php,helpers.php",
+			true,
+		}
+
+		if actual := ToSlice(val.val); !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected %v, actual %v", expected, actual)
+		}
+	})
+}
+
+func TestRegisterStreamWrapperWithPool(t *testing.T) {
+	Initialize()
+	p, err := NewWorkerPool(1)
+	if err != nil {
+		t.Skipf("NewWorkerPool(): %s", err)
+	}
+
+	// Pool threads may be reading the registered stream wrappers at any time.
+	if err := RegisterStreamWrapper("gophp-pool", NewFSStreamWrapper(fstest.MapFS{})); err == nil {
+		t.Errorf("RegisterStreamWrapper(): expected error while worker pool is running")
+	}
+
+	p.Close()
+
+	if err := RegisterStreamWrapper("gophp-pool", NewFSStreamWrapper(fstest.MapFS{})); err != nil {
+		t.Errorf("RegisterStreamWrapper(): %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <pthread.h>
+import "C"
+
+import (
+	"runtime"
+	"sync"
+)
+
+// thread represents a locked OS thread onto which all calls into PHP are
+// marshalled. PHP keeps its runtime state in globals bound to the thread that
+// started the engine, so it is not safe to call into PHP from arbitrary
+// goroutines, which may be scheduled on any OS thread.
+type thread struct {
+	id    C.pthread_t
+	queue chan func()
+
+	// Functions applied ahead of the next request started on the thread, see
+	// Engine.each.
+	lock    sync.Mutex
+	pending []func()
+}
+
+// newThread starts a new goroutine locked to its own OS thread and runs init on
+// it. The thread accepts calls only if init returns successfully, otherwise the
+// thread exits and the error is returned.
+func newThread(init func() error) (*thread, error) {
+	t := &thread{queue: make(chan func())}
+	errs := make(chan error, 1)
+
+	go func() {
+		// The goroutine never unlocks the OS thread, which means the thread is
+		// terminated along with the goroutine.
+		runtime.LockOSThread()
+		t.id = C.pthread_self()
+
+		if err := init(); err != nil {
+			errs <- err
+			return
+		}
+
+		errs <- nil
+
+		for fn := range t.queue {
+			fn()
+		}
+	}()
+
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// current returns true if the caller is running on the thread.
+func (t *thread) current() bool {
+	return C.pthread_equal(C.pthread_self(), t.id) != 0
+}
+
+// call runs fn on the thread and waits for it to return. Calls made from the
+// thread itself, for instance from method receivers called by PHP, run fn
+// directly. Panics raised by fn are propagated to the caller.
+func (t *thread) call(fn func()) {
+	if t.current() {
+		fn()
+		return
+	}
+
+	var p interface{}
+	done := make(chan struct{})
+
+	t.queue <- func() {
+		defer func() {
+			p = recover()
+			close(done)
+		}()
+
+		fn()
+	}
+
+	<-done
+	if p != nil {
+		panic(p)
+	}
+}
+
+// schedule queues fn for running on the thread ahead of the next request started
+// on it, without waiting for any request currently running to finish.
+func (t *thread) schedule(fn func()) {
+	t.lock.Lock()
+	t.pending = append(t.pending, fn)
+	t.lock.Unlock()
+}
+
+// applyPending runs all functions queued via schedule, in order. This is called
+// from the thread itself, before starting a request.
+func (t *thread) applyPending() {
+	t.lock.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.lock.Unlock()
+
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// stop stops accepting calls and terminates the thread once all pending calls
+// have returned.
+func (t *thread) stop() {
+	close(t.queue)
+}
@@ -0,0 +1,330 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "context.h"
+import "C"
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// Error codes for uploaded files, as defined by PHP's UPLOAD_ERR_* constants.
+const (
+	uploadErrOK        = 0
+	uploadErrIniSize   = 1
+	uploadErrNoFile    = 4
+	uploadErrCantWrite = 7
+	uploadErrExtension = 8
+)
+
+// UploadConfig represents the configuration for parsing multipart form data in
+// Go, rather than in PHP. Form values and files are made available to scripts
+// in $_POST and $_FILES as usual, and uploaded files can be checked and moved
+// via is_uploaded_file() and move_uploaded_file().
+//
+// As with uploads handled by PHP, the request body is not available via
+// 'php://input', and files not moved by the script are removed once the
+// request is shut down.
+type UploadConfig struct {
+	// The directory uploaded files are stored in, unless Storage is set.
+	// Defaults to the system temporary directory.
+	Dir string
+
+	// Storage, if set, is called for each uploaded file, and returns the writer
+	// the file is streamed into, along with the name reported to scripts as
+	// 'tmp_name' in $_FILES, and whether the name is a path on the local file
+	// system. Local paths are handled as for files stored in Dir: they are
+	// accepted by is_uploaded_file() and move_uploaded_file(), and removed once
+	// rejected or left unmoved by the script. Other names, such as URLs opened
+	// via a stream wrapper, are not accepted by these functions, and are removed
+	// via Remove instead.
+	Storage func(file *UploadedFile) (w io.WriteCloser, name string, local bool, err error)
+
+	// Remove, if set, is called for files kept by Storage under names other than
+	// local paths, which PHP cannot remove itself. This happens for files failing
+	// to be stored or rejected, and for all other such files once the request is
+	// shut down, in which case files moved elsewhere by the script are expected
+	// to be left in place.
+	Remove func(file *UploadedFile)
+
+	// The maximum size of individual files, in bytes. Larger files are reported
+	// to scripts with UPLOAD_ERR_INI_SIZE. Unlimited if unset.
+	MaxFileBytes int64
+
+	// The maximum number of files stored for a single request, similar to PHP's
+	// 'max_file_uploads'. Any further files are skipped. Unlimited if unset.
+	MaxFiles int
+
+	// Quarantine, if set, is called for each file once stored, and may reject
+	// the file by returning an error, e.g. after scanning its contents. Rejected
+	// files are removed, and reported to scripts with UPLOAD_ERR_EXTENSION.
+	Quarantine func(file *UploadedFile) error
+}
+
+// UploadedFile represents a file uploaded as part of a multipart request.
+type UploadedFile struct {
+	// The form field name and the original file name sent by the client.
+	Field    string
+	Filename string
+
+	// The MIME headers for the multipart section containing the file.
+	Header textproto.MIMEHeader
+
+	// The size of the file, in bytes, set once the file is stored.
+	Size int64
+
+	// The name scripts refer to the file by, as returned by Storage.
+	TmpName string
+}
+
+// form represents multipart form data parsed in Go.
+type form struct {
+	config *UploadConfig
+	values []formValue
+	files  []formFile
+}
+
+type formValue struct {
+	name  string
+	value string
+}
+
+type formFile struct {
+	*UploadedFile
+	err   int
+	local bool
+}
+
+// parseForm parses the multipart form data for the context's HTTP request, if
+// configured to, and returns nil otherwise.
+func parseForm(ctx *Context) (*form, error) {
+	if ctx.Uploads == nil || ctx.body == nil || ctx.Request.Method != http.MethodPost {
+		return nil, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, nil
+	}
+
+	f := &form{config: ctx.Uploads}
+	reader := multipart.NewReader(ctx.body, params["boundary"])
+
+	for stored := 0; ; {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			f.remove()
+			if ctx.body.exceeded {
+				return nil, ErrBodyTooLarge
+			}
+
+			return nil, err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if !isFilePart(part) {
+			value, err := ioutil.ReadAll(part)
+			if err != nil {
+				f.remove()
+				if ctx.body.exceeded {
+					return nil, ErrBodyTooLarge
+				}
+
+				return nil, err
+			}
+
+			f.values = append(f.values, formValue{name, string(value)})
+			continue
+		}
+
+		file := formFile{UploadedFile: &UploadedFile{
+			Field:    name,
+			Filename: part.FileName(),
+			Header:   part.Header,
+		}}
+
+		if file.Filename == "" {
+			file.err = uploadErrNoFile
+		} else if ctx.Uploads.MaxFiles > 0 && stored >= ctx.Uploads.MaxFiles {
+			continue
+		} else if file.err, err = storeFile(ctx.Uploads, &file, part); err != nil {
+			f.remove()
+			if ctx.body.exceeded {
+				return nil, ErrBodyTooLarge
+			}
+
+			return nil, err
+		} else if file.err == uploadErrOK {
+			stored++
+		}
+
+		f.files = append(f.files, file)
+	}
+
+	return f, nil
+}
+
+// isFilePart returns true if the multipart section passed contains a file, even
+// if no file name has been given.
+func isFilePart(part *multipart.Part) bool {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return false
+	}
+
+	_, ok := params["filename"]
+	return ok
+}
+
+// storeFile streams the file contained in the multipart section passed into
+// storage, and returns the upload error code reported to scripts for the file.
+// Errors are only returned if the request body could not be read.
+func storeFile(config *UploadConfig, file *formFile, part *multipart.Part) (int, error) {
+	var w io.WriteCloser
+	var err error
+
+	if config.Storage != nil {
+		w, file.TmpName, file.local, err = config.Storage(file.UploadedFile)
+	} else {
+		var tmp *os.File
+		if tmp, err = ioutil.TempFile(config.Dir, "php"); err == nil {
+			w, file.TmpName, file.local = tmp, tmp.Name(), true
+		}
+	}
+
+	if err != nil {
+		file.TmpName = ""
+		_, err = io.Copy(ioutil.Discard, part)
+		return uploadErrCantWrite, err
+	}
+
+	var src io.Reader = part
+	if config.MaxFileBytes > 0 {
+		src = io.LimitReader(part, config.MaxFileBytes+1)
+	}
+
+	size, err := io.Copy(w, src)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		config.remove(file)
+		file.TmpName = ""
+		return uploadErrCantWrite, err
+	}
+
+	if config.MaxFileBytes > 0 && size > config.MaxFileBytes {
+		config.remove(file)
+		file.TmpName = ""
+		_, err = io.Copy(ioutil.Discard, part)
+		return uploadErrIniSize, err
+	}
+
+	file.Size = size
+
+	if config.Quarantine != nil {
+		if err := config.Quarantine(file.UploadedFile); err != nil {
+			config.remove(file)
+			file.TmpName, file.Size = "", 0
+			return uploadErrExtension, nil
+		}
+	}
+
+	return uploadErrOK, nil
+}
+
+// remove removes the file passed, either from the local file system, or via the
+// Remove function for files kept in custom storage.
+func (config *UploadConfig) remove(file *formFile) {
+	if file.local {
+		os.Remove(file.TmpName)
+	} else if config.Remove != nil {
+		config.Remove(file.UploadedFile)
+	}
+}
+
+// register registers the form values and files into $_POST and $_FILES for the
+// engine context passed. Files stored under local paths are then owned by PHP,
+// which removes any files not moved by the script on request shutdown, while
+// other files are removed via release.
+func (f *form) register(ptr *C.struct__engine_context) {
+	for _, v := range f.values {
+		name, value := C.CString(v.name), C.CString(v.value)
+		C.context_register_post(ptr, name, value, C.size_t(len(v.value)))
+		C.free(unsafe.Pointer(name))
+		C.free(unsafe.Pointer(value))
+	}
+
+	for _, file := range f.files {
+		// As with PHP, the content type is only reported for stored files.
+		var fileType string
+		if file.err == uploadErrOK {
+			fileType = strings.TrimSpace(file.Header.Get("Content-Type"))
+		}
+
+		name := C.CString(file.Field)
+		filename := C.CString(file.Filename)
+		contentType := C.CString(fileType)
+		tmpName := C.CString(file.TmpName)
+
+		local := C.int(0)
+		if file.local {
+			local = 1
+		}
+
+		C.context_register_file(ptr, name, filename, contentType, tmpName, C.int(file.err), C.long(file.Size), local)
+
+		C.free(unsafe.Pointer(name))
+		C.free(unsafe.Pointer(filename))
+		C.free(unsafe.Pointer(contentType))
+		C.free(unsafe.Pointer(tmpName))
+	}
+}
+
+// remove removes all files stored for the form, for requests failing to start
+// before the files are registered.
+func (f *form) remove() {
+	if f == nil {
+		return
+	}
+
+	for i := range f.files {
+		if f.files[i].TmpName != "" {
+			f.config.remove(&f.files[i])
+		}
+	}
+}
+
+// release removes the files registered for the form which are kept in custom
+// storage under names other than local paths, once the request is shut down.
+func (f *form) release() {
+	if f == nil {
+		return
+	}
+
+	for i := range f.files {
+		if f.files[i].TmpName != "" && !f.files[i].local {
+			f.config.remove(&f.files[i])
+		}
+	}
+}
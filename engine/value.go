@@ -51,6 +51,16 @@ const (
 //receivers to PHP functions and classes are only available in the engine scope,
 //and must be predeclared before context execution.
 func NewValue(val interface{}) (*C.struct__zval_struct, error) {
+	var zval *C.struct__zval_struct
+	var err error
+	engine.call(func() {
+		zval, err = newValue(val)
+	})
+
+	return zval, err
+}
+
+func newValue(val interface{}) (*C.struct__zval_struct, error) {
 	zval, err := C.value_new()
 	if err != nil {
 		return &zval, fmt.Errorf("Unable to instantiate PHP value")
@@ -80,7 +90,7 @@ func NewValue(val interface{}) (*C.struct__zval_struct, error) {
 		C.value_set_array(&zval, C.uint(v.Len()))
 
 		for i := 0; i < v.Len(); i++ {
-			vs, err := NewValue(v.Index(i).Interface())
+			vs, err := newValue(v.Index(i).Interface())
 			if err != nil {
 				C._value_destroy(&zval)
 				return nil, err
@@ -96,7 +106,7 @@ func NewValue(val interface{}) (*C.struct__zval_struct, error) {
 			C.value_set_array(&zval, C.uint(v.Len()))
 
 			for _, key := range v.MapKeys() {
-				kv, err := NewValue(v.MapIndex(key).Interface())
+				kv, err := newValue(v.MapIndex(key).Interface())
 				if err != nil {
 					C._value_destroy(&zval)
 					return nil, err
@@ -124,7 +134,7 @@ func NewValue(val interface{}) (*C.struct__zval_struct, error) {
 				continue
 			}
 
-			fv, err := NewValue(v.Field(i).Interface())
+			fv, err := newValue(v.Field(i).Interface())
 			if err != nil {
 				C._value_destroy(&zval)
 				return nil, err
@@ -132,7 +142,7 @@ func NewValue(val interface{}) (*C.struct__zval_struct, error) {
 			str := C.CString(vt.Field(i).Name)
 			C.value_object_property_set(&zval, str, fv)
 			C.free(unsafe.Pointer(str))
-			DestroyValue(fv)
+			destroyValue(fv)
 		}
 	case reflect.Invalid:
 		C.value_set_null(&zval)
@@ -150,30 +160,48 @@ func IsNull(zval *C.struct__zval_struct) bool {
 
 // Kind returns the Value's concrete kind of type.
 func GetKind(zval *C.struct__zval_struct) ValueKind {
+	var kind ValueKind
+	engine.call(func() {
+		kind = getKind(zval)
+	})
+
+	return kind
+}
+
+func getKind(zval *C.struct__zval_struct) ValueKind {
 	return (ValueKind)(C.value_kind(zval))
 }
 
 // Interface returns the internal PHP value as it lies, with no conversion step.
 func ToInterface(zval *C.struct__zval_struct) interface{} {
-	switch GetKind(zval) {
+	var val interface{}
+	engine.call(func() {
+		val = toInterface(zval)
+	})
+
+	return val
+}
+
+func toInterface(zval *C.struct__zval_struct) interface{} {
+	switch getKind(zval) {
 	case IS_LONG:
-		return ToInt(zval)
+		return toInt(zval)
 	case IS_DOUBLE:
-		return ToFloat(zval)
+		return toFloat(zval)
 	case IS_TRUE:
 		return true
 	case IS_FALSE:
 		return false
 	case IS_STRING:
-		return ToString(zval)
+		return toString(zval)
 	case IS_ARRAY:
 		if C.value_array_is_associative(zval) {
-			return ToMap(zval)
+			return toMap(zval)
 		} else {
-			return ToSlice(zval)
+			return toSlice(zval)
 		}
 	case IS_OBJECT:
-		return ToMap(zval)
+		return toMap(zval)
 	}
 
 	return nil
@@ -181,22 +209,58 @@ func ToInterface(zval *C.struct__zval_struct) interface{} {
 
 // Int returns the internal PHP value as an integer, converting if necessary.
 func ToInt(zval *C.struct__zval_struct) int64 {
+	var val int64
+	engine.call(func() {
+		val = toInt(zval)
+	})
+
+	return val
+}
+
+func toInt(zval *C.struct__zval_struct) int64 {
 	return (int64)(C.value_get_long(zval))
 }
 
 // Float returns the internal PHP value as a floating point number, converting
 // if necessary.
 func ToFloat(zval *C.struct__zval_struct) float64 {
+	var val float64
+	engine.call(func() {
+		val = toFloat(zval)
+	})
+
+	return val
+}
+
+func toFloat(zval *C.struct__zval_struct) float64 {
 	return (float64)(C.value_get_double(zval))
 }
 
 // Bool returns the internal PHP value as a boolean, converting if necessary.
 func ToBool(zval *C.struct__zval_struct) bool {
+	var val bool
+	engine.call(func() {
+		val = toBool(zval)
+	})
+
+	return val
+}
+
+func toBool(zval *C.struct__zval_struct) bool {
 	return (bool)(C.value_get_bool(zval))
 }
 
 // String returns the internal PHP value as a string, converting if necessary.
 func ToString(zval *C.struct__zval_struct) string {
+	var val string
+	engine.call(func() {
+		val = toString(zval)
+	})
+
+	return val
+}
+
+func toString(zval *C.struct__zval_struct) string {
 	str := C.value_get_string(zval)
 	defer C.free(unsafe.Pointer(str))
 
@@ -206,6 +270,15 @@ func ToString(zval *C.struct__zval_struct) string {
 // Slice returns the internal PHP value as a slice of interface types. Non-array
 // values are implicitly converted to single-element slices.
 func ToSlice(zval *C.struct__zval_struct) []interface{} {
+	var val []interface{}
+	engine.call(func() {
+		val = toSlice(zval)
+	})
+
+	return val
+}
+
+func toSlice(zval *C.struct__zval_struct) []interface{} {
 	size := (int)(C.value_array_size(zval))
 	val := make([]interface{}, size)
 
@@ -213,8 +286,8 @@ func ToSlice(zval *C.struct__zval_struct) []interface{} {
 
 	for i := 0; i < size; i++ {
 		zval := C.value_array_next_get(zval)
-		val[i] = ToInterface(&zval)
-		DestroyValue(&zval)
+		val[i] = toInterface(&zval)
+		destroyValue(&zval)
 	}
 
 	return val
@@ -223,11 +296,20 @@ func ToSlice(zval *C.struct__zval_struct) []interface{} {
 // Map returns the internal PHP value as a map of interface types, indexed by
 // string keys. Non-array values are implicitly converted to single-element maps
 // with a key of '0'.
-func ToMap(v *C.struct__zval_struct) map[string]interface{} {
+func ToMap(zval *C.struct__zval_struct) map[string]interface{} {
+	var val map[string]interface{}
+	engine.call(func() {
+		val = toMap(zval)
+	})
+
+	return val
+}
+
+func toMap(v *C.struct__zval_struct) map[string]interface{} {
 	val := make(map[string]interface{})
 	keys := C.value_array_keys(v)
-	defer DestroyValue(&keys)
-	for _, k := range ToSlice(&keys) {
+	defer destroyValue(&keys)
+	for _, k := range toSlice(&keys) {
 		fillMap(val, k, v)
 	}
 	return val
@@ -237,21 +319,27 @@ func fillMap(val map[string]interface{}, k interface{}, v *C.struct__zval_struct
 	switch key := k.(type) {
 	case int64:
 		zval := C.value_array_index_get(v, C.ulong(key))
-		defer DestroyValue(&zval)
+		defer destroyValue(&zval)
 		sk := strconv.Itoa((int)(key))
-		val[sk] = ToInterface(&zval)
+		val[sk] = toInterface(&zval)
 	case string:
 		str := C.CString(key)
 		defer C.free(unsafe.Pointer(str))
 		zval := C.value_array_key_get(v, str)
-		defer DestroyValue(&zval)
-		val[key] = ToInterface(&zval)
+		defer destroyValue(&zval)
+		val[key] = toInterface(&zval)
 	}
 }
 
 // Destroy removes all active references to the internal PHP value and frees
 // any resources used.
 func DestroyValue(zval *C.struct__zval_struct) {
+	engine.call(func() {
+		destroyValue(zval)
+	})
+}
+
+func destroyValue(zval *C.struct__zval_struct) {
 	if zval == nil {
 		return
 	}
@@ -0,0 +1,205 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+#include <main/php.h>
+#include <main/SAPI.h>
+#include <main/php_main.h>
+#include <main/php_output.h>
+#include <main/php_variables.h>
+
+#include "context.h"
+#include "worker.h"
+#include "_cgo_export.h"
+
+// Modules holding request state which is reset between requests handled by
+// worker scripts.
+static const char *worker_reset_modules[] = {"session", NULL};
+
+static void worker_reset_modules_state(int startup) {
+	const char **name;
+
+	for (name = worker_reset_modules; *name != NULL; name++) {
+		zend_module_entry *module = zend_hash_str_find_ptr(&module_registry, *name, strlen(*name));
+		if (module == NULL) {
+			continue;
+		}
+
+		if (startup && module->request_startup_func) {
+			module->request_startup_func(module->type, module->module_number);
+		} else if (!startup && module->request_shutdown_func) {
+			module->request_shutdown_func(module->type, module->module_number);
+		}
+	}
+}
+
+// Starts a request within the worker script's own request, activating the SAPI
+// and output layers and registering superglobals for the current server context.
+// This mirrors the relevant parts of php_request_startup().
+static int worker_request_startup() {
+	zend_auto_global *auto_global;
+	int ret = SUCCESS;
+
+	zend_try {
+		php_output_activate();
+
+		PG(header_is_being_sent) = 0;
+		PG(connection_status) = PHP_CONNECTION_NORMAL;
+
+		sapi_activate();
+
+		if (PG(output_handler) && PG(output_handler)[0]) {
+			zval handler;
+			ZVAL_STRING(&handler, PG(output_handler));
+			php_output_start_user(&handler, 0, PHP_OUTPUT_HANDLER_STDFLAGS);
+			zval_ptr_dtor(&handler);
+		} else if (PG(output_buffering)) {
+			php_output_start_user(NULL, PG(output_buffering) > 1 ? PG(output_buffering) : 0, PHP_OUTPUT_HANDLER_STDFLAGS);
+		} else if (PG(implicit_flush)) {
+			php_output_set_implicit_flush(1);
+		}
+
+		php_hash_environment();
+
+		// Superglobals created on demand are otherwise only created when
+		// compiling scripts referring to them, which the worker script will
+		// already have been.
+		ZEND_HASH_FOREACH_PTR(CG(auto_globals), auto_global) {
+			zend_is_auto_global(auto_global->name);
+		} ZEND_HASH_FOREACH_END();
+
+		worker_reset_modules_state(1);
+	} zend_catch {
+		ret = FAILURE;
+	} zend_end_try();
+
+	return ret;
+}
+
+// Shuts down a request started via worker_request_startup(), sending any output
+// and headers remaining. Output buffers, headers and uploaded files are reset
+// along with the SAPI and output layers. This mirrors the relevant parts of
+// php_request_shutdown().
+static void worker_request_shutdown() {
+	zend_auto_global *auto_global;
+	int i;
+
+	zend_try {
+		php_output_end_all();
+	} zend_end_try();
+
+	worker_reset_modules_state(0);
+
+	zend_try {
+		php_output_deactivate();
+	} zend_end_try();
+
+	for (i = 0; i < NUM_TRACK_VARS; i++) {
+		zval_ptr_dtor(&PG(http_globals)[i]);
+		ZVAL_UNDEF(&PG(http_globals)[i]);
+	}
+
+	// Superglobals, including $_SESSION, are removed from the symbol table, as
+	// is the last error, so that none leak into the next request.
+	ZEND_HASH_FOREACH_PTR(CG(auto_globals), auto_global) {
+		zend_hash_del_ind(&EG(symbol_table), auto_global->name);
+	} ZEND_HASH_FOREACH_END();
+
+	zend_hash_str_del_ind(&EG(symbol_table), ZEND_STRL("_SESSION"));
+
+	if (PG(last_error_message)) {
+		free(PG(last_error_message));
+		PG(last_error_message) = NULL;
+	}
+
+	if (PG(last_error_file)) {
+		free(PG(last_error_file));
+		PG(last_error_file) = NULL;
+	}
+
+	zend_try {
+		sapi_deactivate();
+	} zend_end_try();
+
+	// Fields freed, but not reset, by sapi_deactivate(), which is called again
+	// when the worker script's own request is shut down.
+	SG(request_info).content_type_dup = NULL;
+	SG(request_info).auth_user = NULL;
+	SG(request_info).auth_password = NULL;
+	SG(request_info).auth_digest = NULL;
+	SG(request_info).current_user = NULL;
+	SG(rfc1867_uploaded_files) = NULL;
+
+	// Fields referring to the request's engine context, which is freed.
+	SG(request_info).query_string = NULL;
+	SG(request_info).request_method = NULL;
+	SG(request_info).content_type = NULL;
+	SG(request_info).content_length = 0;
+	SG(request_info).cookie_data = NULL;
+}
+
+// Shuts down the request handled by the worker script, which exited while
+// handling it, e.g. due to a fatal error, and restores the worker script's own
+// request state for shutting it down.
+void worker_abort(engine_context *worker, engine_context *context) {
+	worker_request_shutdown();
+
+	SG(server_context) = worker;
+	engineWorkerDone(worker, context);
+
+	context_dtor(context);
+	free(context);
+
+	worker_request_startup();
+	worker->is_worker_started = 0;
+}
+
+PHP_FUNCTION(go_handle_request) /* {{{ */
+{
+	zend_fcall_info fci;
+	zend_fcall_info_cache fcc;
+
+	if (zend_parse_parameters(ZEND_NUM_ARGS(), "f", &fci, &fcc) == FAILURE) {
+		return;
+	}
+
+	engine_context *worker = SG(server_context);
+
+	// The worker script's own request state is replaced by that of each request
+	// handled, and is therefore shut down ahead of handling the first request.
+	if (!worker->is_worker_started) {
+		worker_request_shutdown();
+		worker->is_worker_started = 1;
+	}
+
+	// Blocks until a request is available, or the worker is stopped, in which
+	// case the state is restored for the worker script to exit.
+	engine_context *context = engineWorkerNext(worker);
+	if (context == NULL) {
+		SG(server_context) = worker;
+		worker_request_startup();
+		worker->is_worker_started = 0;
+		RETURN_FALSE;
+	}
+
+	if (worker_request_startup() == SUCCESS && engineWorkerStart(context)) {
+		zval retval;
+
+		ZVAL_UNDEF(&retval);
+		fci.retval = &retval;
+
+		if (zend_call_function(&fci, &fcc) == SUCCESS) {
+			zval_ptr_dtor(&retval);
+		}
+	}
+
+	worker_request_shutdown();
+
+	SG(server_context) = worker;
+	engineWorkerDone(worker, context);
+
+	context_dtor(context);
+	free(context);
+
+	RETURN_TRUE;
+}
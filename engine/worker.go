@@ -0,0 +1,378 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+// #include <stdlib.h>
+// #include <main/php.h>
+// #include "context.h"
+// #include "engine.h"
+// #include "worker.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrWorkerNotThreadSafe is returned by NewWorker for non thread-safe builds of
+// PHP, where the worker script would hold the only engine thread for as long as
+// it runs, blocking all other requests.
+var ErrWorkerNotThreadSafe = errors.New("Cannot create worker for non thread-safe PHP build")
+
+// errWorkerExited is reported for requests interrupted by the worker script
+// exiting, e.g. due to a fatal error.
+var errWorkerExited = errors.New("worker script exited while handling request")
+
+// WorkerConfig represents the configuration for a worker script.
+type WorkerConfig struct {
+	// The worker script, which bootstraps the application once and handles
+	// requests by calling go_handle_request() in a loop, e.g.:
+	//
+	//	<?php
+	//	$app = require 'bootstrap.php';
+	//	while (go_handle_request(function () use ($app) {
+	//		$app->run();
+	//	})) {}
+	//
+	// Superglobals, sessions, output buffers, headers and uploaded files are
+	// reset for each request handled. Requests completed via
+	// fastcgi_finish_request() are responded to immediately, while the script
+	// continues handling the request in the background.
+	Script string
+
+	// The document root requests are handled for, as reported in $_SERVER.
+	DocumentRoot string
+
+	// The number of requests handled before the worker script is restarted,
+	// e.g. for containing memory leaks. Never restarted if left unset. Worker
+	// scripts are always restarted once exiting, e.g. due to a fatal error.
+	MaxRequests int
+
+	// The writer used for debug output and errors produced by the worker
+	// script, as well as output produced outside of requests.
+	Log io.Writer
+
+	// Context, if set, is called with the context for each request before the
+	// request is handled, and may be used for setting further options, e.g.
+	// Context.TrustedProxies or Context.OnSendHeaders.
+	Context func(ctx *Context)
+}
+
+// Worker represents a long-running worker script, and implements http.Handler by
+// passing each request to the worker script.
+//
+// The worker script runs on a dedicated engine thread of its own, and workers
+// are therefore only available when building against a thread-safe (ZTS) build
+// of PHP, using the 'php7.zts' build tag, rather than the non thread-safe build
+// shipped by most distributions. Changes made to the engine, e.g. via
+// Define, apply to worker scripts once restarted.
+type Worker struct {
+	config   WorkerConfig
+	thread   *thread
+	requests chan *workerRequest
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// workerRequest represents a single request passed to a worker script.
+type workerRequest struct {
+	ctx  *Context
+	err  error
+	done chan struct{}
+
+	// Closed once the request is completed via fastcgi_finish_request(), and
+	// used as the context's Finished channel.
+	finished chan struct{}
+}
+
+// workerState represents the state of a running worker script.
+type workerState struct {
+	worker  *Worker
+	handled int
+	current *workerRequest
+}
+
+// NewWorker starts the worker script configured on a new engine thread, which is
+// restarted whenever exiting, until the worker is closed. ErrWorkerNotThreadSafe
+// is returned for the default, non thread-safe build of PHP.
+func NewWorker(config WorkerConfig) (*Worker, error) {
+	if engine == nil {
+		return nil, errors.New("engine is not initialized")
+	}
+
+	if C.engine_thread_safe() == 0 {
+		return nil, ErrWorkerNotThreadSafe
+	}
+
+	if config.Script == "" {
+		return nil, errors.New("no worker script given")
+	}
+
+	w := &Worker{
+		config:   config,
+		requests: make(chan *workerRequest),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	engine.threads.Lock()
+	defer engine.threads.Unlock()
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	t, err := newEngineThread()
+	if err != nil {
+		return nil, err
+	}
+
+	w.thread = t
+	engine.workers = append(engine.workers, w)
+
+	go w.run()
+
+	return w, nil
+}
+
+// ServeHTTP passes the request to the worker script, waiting for the script to
+// become available if needed.
+func (w *Worker) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	ctx := &Context{
+		Log:            w.config.Log,
+		Request:        r,
+		ResponseWriter: rw,
+		DocumentRoot:   w.config.DocumentRoot,
+		ScriptFileName: w.config.Script,
+	}
+
+	if w.config.Context != nil {
+		w.config.Context(ctx)
+	}
+
+	if err := prepareBody(ctx); err == ErrBodyTooLarge {
+		http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	req := &workerRequest{ctx: ctx, done: make(chan struct{}), finished: make(chan struct{})}
+
+	select {
+	case w.requests <- req:
+	case <-w.stop:
+		ctx.form.remove()
+		http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		ctx.form.remove()
+		return
+	}
+
+	// Requests completed via fastcgi_finish_request() may continue running in
+	// the background, after the response writer and request body have been
+	// detached from the context.
+	select {
+	case <-req.done:
+	case <-req.finished:
+		return
+	}
+
+	switch {
+	case req.err != nil && !ctx.wroteHeader:
+		w.log("%s", req.err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	case req.err != nil:
+		w.log("%s", req.err)
+	}
+}
+
+// Close stops the worker script once any active request has been handled, and
+// waits for it to exit.
+func (w *Worker) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+
+	<-w.done
+}
+
+// run runs the worker script until the worker is closed, and stops the worker's
+// thread once done.
+func (w *Worker) run() {
+	defer close(w.done)
+	defer func() {
+		engine.lock.Lock()
+		for i := range engine.workers {
+			if engine.workers[i] == w {
+				engine.workers = append(engine.workers[:i], engine.workers[i+1:]...)
+				break
+			}
+		}
+		engine.lock.Unlock()
+
+		stopEngineThread(w.thread)
+	}()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		state := &workerState{worker: w}
+		if err := w.serve(state); err != nil {
+			w.log("Worker script '%s' failed: %s", w.config.Script, err)
+		}
+
+		// Avoid restarting scripts failing to handle any requests in a loop.
+		if state.handled == 0 {
+			select {
+			case <-w.stop:
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// serve runs the worker script once on the worker's thread, until it exits.
+func (w *Worker) serve(state *workerState) error {
+	ctx := &Context{
+		Output:         w.config.Log,
+		Log:            w.config.Log,
+		DocumentRoot:   w.config.DocumentRoot,
+		ScriptFileName: w.config.Script,
+		worker:         state,
+	}
+
+	var err error
+	w.thread.call(func() {
+		if err = requestStartup(ctx, w.thread); err != nil {
+			return
+		}
+
+		ctx.thread = w.thread
+		err = ctx.Exec(w.config.Script)
+
+		// Scripts exiting while handling a request, e.g. due to a fatal error,
+		// send any remaining output for the request, which is then shut down
+		// along with its context.
+		if req := state.current; req != nil {
+			req.err = errWorkerExited
+			C.worker_abort(ctx.context, req.ctx.context)
+		}
+
+		requestShutdown(ctx)
+		ctx.thread = nil
+	})
+
+	return err
+}
+
+func (w *Worker) log(format string, args ...interface{}) {
+	if w.config.Log != nil {
+		fmt.Fprintf(w.config.Log, format+"\n", args...)
+	}
+}
+
+//export engineWorkerNext
+func engineWorkerNext(ptr *C.struct__engine_context) *C.struct__engine_context {
+	worker := engine.context(ptr)
+	if worker == nil || worker.worker == nil {
+		return nil
+	}
+
+	state := worker.worker
+	if state.worker.config.MaxRequests > 0 && state.handled >= state.worker.config.MaxRequests {
+		return nil
+	}
+
+	for {
+		var req *workerRequest
+
+		select {
+		case req = <-state.worker.requests:
+		case <-state.worker.stop:
+			return nil
+		}
+
+		ctx, err := newContext(req.ctx)
+		if err != nil {
+			req.ctx.form.remove()
+			req.err = err
+			close(req.done)
+			continue
+		}
+
+		req.ctx.thread = worker.thread
+		req.ctx.finished = req.finished
+		state.current = req
+
+		return ctx
+	}
+}
+
+//export engineWorkerStart
+func engineWorkerStart(ptr *C.struct__engine_context) C.int {
+	ctx := engine.context(ptr)
+	if ctx == nil {
+		return 0
+	}
+
+	// Requests with bodies exceeding the maximum size are not passed to the
+	// worker script.
+	if ctx.body != nil && ctx.body.exceeded {
+		ctx.rejectBody()
+		ctx.form.remove()
+		return 0
+	}
+
+	if ctx.body != nil {
+		ctx.body.reject = true
+	}
+
+	if ctx.form != nil {
+		ctx.form.register(ptr)
+		ctx.uploads, ctx.form = ctx.form, nil
+	}
+
+	return 1
+}
+
+//export engineWorkerDone
+func engineWorkerDone(workerPtr *C.struct__engine_context, ptr *C.struct__engine_context) {
+	worker := engine.context(workerPtr)
+	if worker == nil || worker.worker == nil || worker.worker.current == nil {
+		return
+	}
+
+	state := worker.worker
+	req := state.current
+	state.current = nil
+	state.handled++
+
+	engine.lock.Lock()
+	delete(engine.contexts, ptr)
+	engine.lock.Unlock()
+
+	ctx := req.ctx
+	ctx.destroyPrograms()
+	ctx.uploads.release()
+	ctx.uploads = nil
+	if ctx.output != nil {
+		ctx.output.Flush()
+		ctx.output = nil
+	}
+
+	ctx.context, ctx.thread = nil, nil
+	close(req.done)
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var workerScript = `<?php
+$handled = 0;
+while (go_handle_request(function () use (&$handled) {
+	$handled++;
+	if (isset($_GET['fatal'])) {
+		undefined_function();
+	}
+
+	if (isset($_GET['finish'])) {
+		echo 'finished';
+		fastcgi_finish_request();
+		usleep(100000);
+		echo 'discarded';
+		return;
+	}
+
+	header('X-Handled: ' . $handled);
+	echo (isset($_GET['name']) ? $_GET['name'] : '-') . ':' . $handled;
+})) {}`
+
+var workerTests = []struct {
+	query    string
+	code     int
+	expected string
+}{
+	{"name=a", 200, "a:1"},
+	{"name=b", 200, "b:2"},
+	{"name=c", 200, "c:1"}, // Restarted after reaching MaxRequests.
+	{"fatal=1", 500, ""},
+	{"name=d", 200, "d:1"}, // Restarted after a fatal error.
+	{"", 200, "-:2"},       // Superglobals reset between requests.
+	{"finish=1", 200, "finished"},
+	{"name=e", 200, "e:2"},
+}
+
+func TestWorker(t *testing.T) {
+	Initialize()
+	script, err := NewScript("worker.php", workerScript)
+	if err != nil {
+		t.Fatalf("Could not create worker script for testing: %s", err)
+	}
+	defer script.Remove()
+
+	w, err := NewWorker(WorkerConfig{Script: script.Name(), MaxRequests: 2})
+	if err == ErrWorkerNotThreadSafe {
+		// Workers are only available for thread-safe (ZTS) builds of PHP.
+		t.Skipf("NewWorker(): %s", err)
+	} else if err != nil {
+		t.Fatalf("NewWorker(): %s", err)
+	}
+	defer w.Close()
+
+	for _, tt := range workerTests {
+		recorder := httptest.NewRecorder()
+		w.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+
+		if recorder.Code != tt.code {
+			t.Errorf("Worker.ServeHTTP('%s'): expected status %d, actual %d", tt.query, tt.code, recorder.Code)
+		}
+
+		if tt.code == 200 && recorder.Body.String() != tt.expected {
+			t.Errorf("Worker.ServeHTTP('%s'): expected '%s', actual '%s'", tt.query, tt.expected, recorder.Body.String())
+		}
+	}
+}
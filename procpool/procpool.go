@@ -0,0 +1,431 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+// Package procpool provides a pool of worker processes serving HTTP requests,
+// as an alternative to running the PHP engine on multiple threads, which is
+// only possible for thread-safe (ZTS) builds of PHP.
+//
+// Workers are child processes running the same binary as the parent process,
+// each of which initializes its own engine and serves requests forwarded by the
+// parent over a local socket. Programs using the pool are expected to check
+// whether they are running as a worker before doing anything else:
+//
+//	func main() {
+//		if procpool.IsWorker() {
+//			engine.Initialize()
+//			log.Fatal(procpool.Serve(handler))
+//		}
+//
+//		pool, err := procpool.New(procpool.Config{Workers: 4, MaxRequests: 500})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//
+//		log.Fatal(http.ListenAndServe(":8080", pool))
+//	}
+package procpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The environment variable containing the socket path for worker processes.
+const workerEnv = "GOPHP_PROCPOOL_SOCKET"
+
+// Config represents the configuration for a worker pool.
+type Config struct {
+	// The number of worker processes to run. Defaults to 1.
+	Workers int
+
+	// The number of requests served by a worker process before it is replaced
+	// by a new process, similar to PHP-FPM's 'pm.max_requests'. Workers are
+	// never replaced if left unset.
+	MaxRequests int
+
+	// The time to wait for a worker process to start accepting requests.
+	// Defaults to 10 seconds.
+	StartTimeout time.Duration
+
+	// The directory the worker sockets are created in. Defaults to the system
+	// temporary directory.
+	Dir string
+
+	// Command-line arguments and additional environment variables passed to
+	// worker processes. Arguments default to those of the parent process.
+	Args []string
+	Env  []string
+
+	// Writers for the standard output and error of worker processes. Output is
+	// discarded if left unset.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Logger used for reporting worker failures. Defaults to the standard logger.
+	Logger *log.Logger
+}
+
+// Pool represents a set of worker processes, and implements http.Handler by
+// forwarding each request to the next idle worker.
+type Pool struct {
+	config Config
+	idle   chan *worker
+
+	lock    sync.Mutex
+	closed  bool
+	closing chan struct{}
+	workers map[*worker]struct{}
+	seq     int
+	wait    sync.WaitGroup
+}
+
+// IsWorker returns true if the current process has been started as a worker
+// process by a Pool.
+func IsWorker() bool {
+	return os.Getenv(workerEnv) != ""
+}
+
+// Serve accepts requests forwarded by the parent process and serves them using
+// the handler passed. Serve returns once the parent process retires the worker
+// or exits, and returns an error if the process has not been started as a
+// worker, or if the socket could not be opened.
+func Serve(handler http.Handler) error {
+	socket := os.Getenv(workerEnv)
+	if socket == "" {
+		return errors.New("procpool: process not started as a pool worker")
+	}
+
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: handler}
+
+	// The parent process holds the write end of our standard input open for as
+	// long as the worker is expected to run. Active requests are finished before
+	// returning, as Serve returns as soon as the shutdown starts.
+	shutdown := make(chan error, 1)
+	go func() {
+		io.Copy(ioutil.Discard, os.Stdin)
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	if err = server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+
+	return <-shutdown
+}
+
+// New starts a pool of worker processes, and returns an error if any of them
+// failed to start.
+func New(config Config) (*Pool, error) {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+
+	if config.StartTimeout == 0 {
+		config.StartTimeout = 10 * time.Second
+	}
+
+	if config.Dir == "" {
+		config.Dir = os.TempDir()
+	}
+
+	if config.Args == nil && len(os.Args) > 1 {
+		config.Args = os.Args[1:]
+	}
+
+	if config.Logger == nil {
+		config.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	p := &Pool{
+		config:  config,
+		idle:    make(chan *worker, config.Workers),
+		closing: make(chan struct{}),
+		workers: make(map[*worker]struct{}),
+	}
+
+	for i := 0; i < config.Workers; i++ {
+		w, err := p.start()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+
+		p.idle <- w
+	}
+
+	return p, nil
+}
+
+// ServeHTTP forwards the request to the next idle worker process, waiting for
+// one to become available if needed. Requests are rejected with status 503 once
+// the pool has been closed.
+func (p *Pool) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var w *worker
+
+	for w == nil {
+		select {
+		case w = <-p.idle:
+		case <-p.closing:
+		case <-req.Context().Done():
+			return
+		}
+
+		// Workers are no longer returned to the idle queue once the pool has
+		// been closed, so waiting requests would never be served.
+		p.lock.Lock()
+		closed := p.closed
+		p.lock.Unlock()
+
+		if closed {
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		// Workers may have exited while idle, in which case they have already
+		// been replaced.
+		if w.done() {
+			w = nil
+		}
+	}
+
+	w.proxy.ServeHTTP(rw, req)
+	w.requests++
+
+	if w.retired() {
+		return
+	}
+
+	if p.config.MaxRequests > 0 && w.requests >= p.config.MaxRequests {
+		w.stop()
+		return
+	}
+
+	p.idle <- w
+}
+
+// Close stops all worker processes, waiting for any active requests to finish.
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.closing)
+	}
+	for w := range p.workers {
+		w.stop()
+	}
+	p.lock.Unlock()
+
+	p.wait.Wait()
+	return nil
+}
+
+// start starts a new worker process and waits for it to accept connections.
+func (p *Pool) start() (*worker, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	p.seq++
+	socket := filepath.Join(p.config.Dir, fmt.Sprintf("gophp-%d-%d.sock", os.Getpid(), p.seq))
+	p.lock.Unlock()
+
+	w := &worker{
+		cmd:      exec.Command(exe, p.config.Args...),
+		socket:   socket,
+		stopping: make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+
+	w.cmd.Env = append(append(os.Environ(), p.config.Env...), workerEnv+"="+socket)
+	w.cmd.Stdout = p.config.Stdout
+	w.cmd.Stderr = p.config.Stderr
+
+	if w.stdin, err = w.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+
+	if err = w.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		w.err = w.cmd.Wait()
+		os.Remove(socket)
+		close(w.exited)
+	}()
+
+	if err = w.ready(p.config.StartTimeout); err != nil {
+		w.cmd.Process.Kill()
+		<-w.exited
+		return nil, err
+	}
+
+	w.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = "procpool"
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			// Requests canceled by the client are not the worker's fault, and
+			// there is no one left to respond to.
+			if req.Context().Err() != nil {
+				return
+			}
+
+			p.config.Logger.Printf("procpool: worker %d failed to serve request: %s", w.cmd.Process.Pid, err)
+			rw.WriteHeader(http.StatusBadGateway)
+
+			// Workers failing to serve requests are assumed to be broken, and
+			// are replaced once stopped.
+			w.stop()
+		},
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		w.stop()
+		<-w.exited
+		return nil, errors.New("procpool: pool is closed")
+	}
+
+	p.workers[w] = struct{}{}
+	p.wait.Add(1)
+
+	go p.monitor(w)
+
+	return w, nil
+}
+
+// monitor waits for the worker process to exit, and starts a replacement worker
+// unless the pool has been closed.
+func (p *Pool) monitor(w *worker) {
+	defer p.wait.Done()
+
+	<-w.exited
+
+	p.lock.Lock()
+	delete(p.workers, w)
+	closed := p.closed
+	p.lock.Unlock()
+
+	if closed {
+		return
+	}
+
+	select {
+	case <-w.stopping:
+	default:
+		p.config.Logger.Printf("procpool: worker %d exited unexpectedly: %v", w.cmd.Process.Pid, w.err)
+	}
+
+	for {
+		r, err := p.start()
+		if err == nil {
+			p.idle <- r
+			return
+		}
+
+		p.lock.Lock()
+		closed := p.closed
+		p.lock.Unlock()
+
+		if closed {
+			return
+		}
+
+		p.config.Logger.Printf("procpool: failed to start worker: %s", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// worker represents a single worker process.
+type worker struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	socket   string
+	proxy    *httputil.ReverseProxy
+	requests int
+
+	once     sync.Once
+	stopping chan struct{}
+	exited   chan struct{}
+	err      error
+}
+
+// ready waits for the worker process to accept connections on its socket.
+func (w *worker) ready(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		if w.done() {
+			return errors.New("procpool: worker exited before accepting requests")
+		}
+
+		if conn, err := net.Dial("unix", w.socket); err == nil {
+			conn.Close()
+			return nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return errors.New("procpool: timed out waiting for worker to accept requests")
+}
+
+// stop signals the worker process to exit once all active requests are served.
+func (w *worker) stop() {
+	w.once.Do(func() {
+		close(w.stopping)
+		w.stdin.Close()
+	})
+}
+
+// retired returns true if the worker has been stopped or has exited, and should
+// not be handed any further requests.
+func (w *worker) retired() bool {
+	select {
+	case <-w.stopping:
+		return true
+	default:
+		return w.done()
+	}
+}
+
+// done returns true if the worker process has exited.
+func (w *worker) done() bool {
+	select {
+	case <-w.exited:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2016 Alexander Palaistras. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package procpool
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The test binary doubles as the worker binary for pools started in tests.
+	if IsWorker() {
+		err := Serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/crash" {
+				os.Exit(1)
+			}
+
+			if r.URL.Path == "/slow" {
+				time.Sleep(500 * time.Millisecond)
+			}
+
+			fmt.Fprint(w, os.Getpid())
+		}))
+		if err != nil {
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func get(t *testing.T, p *Pool, path string) (int, string) {
+	rw := httptest.NewRecorder()
+	p.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+
+	body, err := ioutil.ReadAll(rw.Result().Body)
+	if err != nil {
+		t.Fatalf("Pool.ServeHTTP('%s'): %s", path, err)
+	}
+
+	return rw.Code, string(body)
+}
+
+func TestPoolServe(t *testing.T) {
+	p, err := New(Config{Workers: 2, Args: []string{}})
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	defer p.Close()
+
+	pids := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		code, body := get(t, p, "/")
+		if code != http.StatusOK {
+			t.Fatalf("Pool.ServeHTTP(): expected status 200, actual %d", code)
+		}
+
+		pids[body] = true
+	}
+
+	if len(pids) != 2 {
+		t.Errorf("Pool.ServeHTTP(): expected requests served by 2 workers, actual %d", len(pids))
+	}
+}
+
+func TestPoolMaxRequests(t *testing.T) {
+	p, err := New(Config{Workers: 1, MaxRequests: 2, Args: []string{}})
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	defer p.Close()
+
+	_, first := get(t, p, "/")
+	_, second := get(t, p, "/")
+	_, third := get(t, p, "/")
+
+	if first != second {
+		t.Errorf("Pool.ServeHTTP(): worker replaced before reaching max requests")
+	}
+
+	if third == second {
+		t.Errorf("Pool.ServeHTTP(): worker not replaced after reaching max requests")
+	}
+}
+
+func TestPoolCrash(t *testing.T) {
+	p, err := New(Config{Workers: 1, Args: []string{}})
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	defer p.Close()
+
+	_, before := get(t, p, "/")
+
+	if code, _ := get(t, p, "/crash"); code != http.StatusBadGateway {
+		t.Errorf("Pool.ServeHTTP('/crash'): expected status 502, actual %d", code)
+	}
+
+	code, after := get(t, p, "/")
+	if code != http.StatusOK {
+		t.Fatalf("Pool.ServeHTTP(): expected status 200 after crash, actual %d", code)
+	}
+
+	if before == after {
+		t.Errorf("Pool.ServeHTTP(): crashed worker not replaced")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p, err := New(Config{Workers: 1, Args: []string{}})
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+
+	// Active requests are finished, while requests waiting for a worker are
+	// rejected once the pool is closed.
+	expected := map[string]int{"/slow": http.StatusOK, "/": http.StatusServiceUnavailable}
+	codes := make(map[string]chan int)
+	for _, path := range []string{"/slow", "/"} {
+		codes[path] = make(chan int, 1)
+		go func(path string, codes chan<- int) {
+			code, _ := get(t, p, path)
+			codes <- code
+		}(path, codes[path])
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	p.Close()
+
+	for path, code := range expected {
+		select {
+		case actual := <-codes[path]:
+			if actual != code {
+				t.Errorf("Pool.ServeHTTP('%s'): expected status %d while closing, actual %d", path, code, actual)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Pool.ServeHTTP('%s'): request still waiting after pool closed", path)
+		}
+	}
+
+	if code, _ := get(t, p, "/"); code != http.StatusServiceUnavailable {
+		t.Errorf("Pool.ServeHTTP(): expected status 503 after pool closed, actual %d", code)
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	p, err := New(Config{Workers: 1, Args: []string{}})
+	if err != nil {
+		t.Fatalf("New(): %s", err)
+	}
+	defer p.Close()
+
+	_, before := get(t, p, "/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	rw := httptest.NewRecorder()
+	p.ServeHTTP(rw, httptest.NewRequest("GET", "/slow", nil).WithContext(ctx))
+
+	if rw.Code != http.StatusOK || rw.Body.Len() != 0 {
+		t.Errorf("Pool.ServeHTTP('/slow'): expected nothing written for canceled request, actual status %d", rw.Code)
+	}
+
+	code, after := get(t, p, "/")
+	if code != http.StatusOK {
+		t.Fatalf("Pool.ServeHTTP(): expected status 200 after canceled request, actual %d", code)
+	}
+
+	if before != after {
+		t.Errorf("Pool.ServeHTTP(): worker replaced after canceled request")
+	}
+}